@@ -7,6 +7,7 @@ import (
 	"net/url"
 	"os"
 	"path/filepath"
+	"strings"
 	"time"
 
 	"github.com/rostved/dinero-backup/dinero"
@@ -25,7 +26,7 @@ type ContactsResponse struct {
 	} `json:"Pagination"`
 }
 
-func BackupContacts(client *dinero.Client, stateManager *state.Manager, outDir string, dryRun bool) error {
+func BackupContacts(client *dinero.Client, stateManager *state.Manager, outDir string, dryRun bool, fields []string, csvDialect *CSVDialect) error {
 	log.Println("Backing up Contacts...")
 
 	if !dryRun {
@@ -35,6 +36,10 @@ func BackupContacts(client *dinero.Client, stateManager *state.Manager, outDir s
 	}
 
 	lastSync := stateManager.GetLastSyncContacts()
+	if fieldSelectionChanged(stateManager, "contacts", fields) {
+		log.Println("CONTACT_FIELDS changed since the last run, fetching all contacts again.")
+		lastSync = state.DefaultState.LastSync.Contacts
+	}
 	now := time.Now().UTC()
 
 	// Fetch all contacts with pagination
@@ -43,14 +48,8 @@ func BackupContacts(client *dinero.Client, stateManager *state.Manager, outDir s
 	pageSize := 100
 
 	for {
-		fields := "" +
-			"Name,ContactGuid,ExternalReference,IsPerson,Street,ZipCode,City,CountryKey,Phone," +
-			"Email,Webpage,AttPerson,VatNumber,EanNumber,PaymentConditionType,PaymentConditionNumberOfDays," +
-			"IsMember,MemberNumber,CompanyStatus,VatRegionKey,CreatedAt,UpdatedAt,DeletedAt,PreferredInvoiceLanguageKey," +
-			"PreferredInvoiceCurrencyKey"
-
 		params := url.Values{}
-		params.Set("fields", fields)
+		params.Set("fields", strings.Join(fields, ","))
 		params.Set("changesSince", lastSync)
 		params.Set("page", fmt.Sprintf("%d", page))
 		params.Set("pageSize", fmt.Sprintf("%d", pageSize))
@@ -59,6 +58,12 @@ func BackupContacts(client *dinero.Client, stateManager *state.Manager, outDir s
 		if err != nil {
 			return fmt.Errorf("failed to fetch contacts: %w", err)
 		}
+		if err := validateResponse("contacts", data, fields); err != nil {
+			return err
+		}
+		if page == 0 {
+			checkSelectedFields("contacts", data, fields)
+		}
 
 		var response ContactsResponse
 		if err := json.Unmarshal(data, &response); err != nil {
@@ -78,6 +83,15 @@ func BackupContacts(client *dinero.Client, stateManager *state.Manager, outDir s
 
 	if len(allContacts) == 0 {
 		log.Println("No contact changes found (not updating lastSync).")
+		if !dryRun {
+			stateManager.UpdateSelectedFields("contacts", fieldSelectionKey(fields))
+			if err := stateManager.Save(); err != nil {
+				return err
+			}
+		}
+		if csvDialect != nil {
+			return saveContactsCSV(outDir, csvDialect, dryRun)
+		}
 		return nil
 	}
 
@@ -105,6 +119,7 @@ func BackupContacts(client *dinero.Client, stateManager *state.Manager, outDir s
 		log.Printf("Saved %d contacts to %s", len(mergedContacts), filename)
 
 		stateManager.UpdateContacts(now.Format(time.RFC3339))
+		stateManager.UpdateSelectedFields("contacts", fieldSelectionKey(fields))
 		if err := stateManager.Save(); err != nil {
 			return err
 		}
@@ -112,6 +127,9 @@ func BackupContacts(client *dinero.Client, stateManager *state.Manager, outDir s
 		log.Printf("[Dry Run] Would save %d contacts to %s", len(mergedContacts), filename)
 	}
 
+	if csvDialect != nil {
+		return saveContactsCSV(outDir, csvDialect, dryRun)
+	}
 	return nil
 }
 
@@ -1,78 +1,94 @@
 package backup
 
 import (
-	"bytes"
 	"encoding/json"
 	"fmt"
 	"sort"
-	"strings"
 )
 
-// EntriesToCSV converts entries JSON data to CSV format matching Dinero's export
-func EntriesToCSV(jsonData []byte) ([]byte, error) {
+// EntriesToCSV converts entries JSON data to CSV in the given dialect. The default dialect
+// matches Dinero's export.
+func EntriesToCSV(jsonData []byte, dialect *CSVDialect) ([]byte, error) {
 	var entries []Entry
 	if err := json.Unmarshal(jsonData, &entries); err != nil {
 		return nil, fmt.Errorf("failed to parse entries JSON: %w", err)
 	}
 
+	// Entries deleted in Dinero are kept in the JSON for audit but don't count towards saldo
+	entries = activeEntries(entries)
+
 	// Sort entries by AccountNumber, then by Date
-	sort.Slice(entries, func(i, j int) bool {
+	sort.SliceStable(entries, func(i, j int) bool {
 		if entries[i].AccountNumber != entries[j].AccountNumber {
 			return entries[i].AccountNumber < entries[j].AccountNumber
 		}
 		return entries[i].Date < entries[j].Date
 	})
 
-	var buf bytes.Buffer
-
-	// Write UTF-8 BOM for Excel compatibility
-	buf.Write([]byte{0xEF, 0xBB, 0xBF})
-
-	// Write header (CRLF line endings for Windows/Excel compatibility)
-	buf.WriteString("Konto;Kontonavn;Dato;Bilag;Bilagstype;Tekst;Momstype;Beløb;Saldo\r\n")
+	header := make([]string, len(dialect.Columns))
+	for i, column := range dialect.Columns {
+		header[i] = dialect.header(column)
+	}
+	rows := [][]string{header}
 
 	// Track running balance per account
-	balances := make(map[int]float64)
+	balances := make(map[int]Money)
 
 	for _, entry := range entries {
-		// Update running balance
 		balances[entry.AccountNumber] += entry.Amount
 
-		// Format voucher number
-		bilag := ""
-		if entry.VoucherNumber != nil {
-			bilag = fmt.Sprintf("%d", *entry.VoucherNumber)
+		row := make([]string, len(dialect.Columns))
+		for i, column := range dialect.Columns {
+			row[i] = entryColumnValue(entry, column, balances[entry.AccountNumber], dialect)
 		}
+		rows = append(rows, row)
+	}
 
-		// Map voucher type to Danish label
-		bilagstype := mapVoucherType(entry.VoucherType, entry.Type)
-
-		// Format amount in Danish format (comma as decimal separator)
-		beloeb := formatDanishNumber(entry.Amount)
-
-		// Format running balance
-		saldo := formatDanishNumber(balances[entry.AccountNumber])
+	return dialect.write(rows)
+}
 
-		// Build CSV line (CRLF line endings for Windows/Excel compatibility)
-		line := fmt.Sprintf("%d;%s;%s;%s;%s;%s;%s;%s;%s\r\n",
-			entry.AccountNumber,
-			entry.AccountName,
-			entry.Date,
-			bilag,
-			bilagstype,
-			entry.Description,
-			entry.VatType,
-			beloeb,
-			saldo,
-		)
-		buf.WriteString(line)
+// entryColumnValue formats a column of an entry; balance is the account's running balance
+func entryColumnValue(entry Entry, column string, balance Money, dialect *CSVDialect) string {
+	switch column {
+	case "account":
+		return fmt.Sprintf("%d", entry.AccountNumber)
+	case "account_name":
+		return entry.AccountName
+	case "date":
+		return dialect.FormatDate(entry.Date)
+	case "voucher":
+		if entry.VoucherNumber != nil {
+			return fmt.Sprintf("%d", *entry.VoucherNumber)
+		}
+		return ""
+	case "voucher_type":
+		if dialect.Language == "en" {
+			return voucherTypeNameEnglish(entry.VoucherType, entry.Type)
+		}
+		return VoucherTypeName(entry.VoucherType, entry.Type)
+	case "description":
+		return entry.Description
+	case "vat_type":
+		return entry.VatType
+	case "vat_code":
+		return entry.VatCode
+	case "amount":
+		return dialect.FormatNumber(entry.Amount)
+	case "balance":
+		return dialect.FormatNumber(balance)
+	case "entry_guid":
+		return entry.EntryGuid
+	case "contact_guid":
+		if entry.ContactGuid != nil {
+			return *entry.ContactGuid
+		}
+		return ""
 	}
-
-	return buf.Bytes(), nil
+	return ""
 }
 
-// mapVoucherType converts the API VoucherType to Danish label matching Dinero's export
-func mapVoucherType(voucherType *string, entryType string) string {
+// VoucherTypeName converts the API VoucherType to Danish label matching Dinero's export
+func VoucherTypeName(voucherType *string, entryType string) string {
 	// Primo entries
 	if entryType == "Primo" {
 		return "---"
@@ -94,37 +110,20 @@ func mapVoucherType(voucherType *string, entryType string) string {
 	}
 }
 
-// formatDanishNumber formats a number in Danish format (comma as decimal, dot as thousands)
-func formatDanishNumber(n float64) string {
-	// Format with 2 decimal places
-	str := fmt.Sprintf("%.2f", n)
-
-	// Split into integer and decimal parts
-	parts := strings.Split(str, ".")
-	intPart := parts[0]
-	decPart := parts[1]
-
-	// Handle negative numbers
-	negative := false
-	if strings.HasPrefix(intPart, "-") {
-		negative = true
-		intPart = intPart[1:]
-	}
-
-	// Add thousand separators (dots)
-	var result strings.Builder
-	for i, c := range intPart {
-		if i > 0 && (len(intPart)-i)%3 == 0 {
-			result.WriteByte('.')
-		}
-		result.WriteRune(c)
+// voucherTypeNameEnglish is the English counterpart of VoucherTypeName
+func voucherTypeNameEnglish(voucherType *string, entryType string) string {
+	if entryType == "Primo" || voucherType == nil {
+		return "---"
 	}
 
-	// Combine with comma as decimal separator
-	formatted := result.String() + "," + decPart
-
-	if negative {
-		return "-" + formatted
+	switch *voucherType {
+	case "Sales":
+		return "Sales invoice"
+	case "Purchases":
+		return "Purchase"
+	case "manuel":
+		return "Manual voucher"
+	default:
+		return *voucherType
 	}
-	return formatted
 }
@@ -0,0 +1,358 @@
+package backup
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// resourceColumn is a known field of a resource, in CSV column order
+type resourceColumn struct {
+	Field string
+	Da    string
+	En    string
+	// Amount fields are formatted as money with the dialect's separators
+	Amount bool
+}
+
+// resourceColumns are the known fields of the JSON resources. Fields that aren't listed (e.g.
+// fields selected with INVOICE_FIELDS=all) follow in alphabetical order, headed by their field name.
+var resourceColumns = map[string][]resourceColumn{
+	"invoices": {
+		{Field: "Number", Da: "Nummer", En: "Number"},
+		{Field: "Date", Da: "Dato", En: "Date"},
+		{Field: "PaymentDate", Da: "Forfaldsdato", En: "Due date"},
+		{Field: "ContactName", Da: "Kunde", En: "Customer"},
+		{Field: "Description", Da: "Beskrivelse", En: "Description"},
+		{Field: "Status", Da: "Status", En: "Status"},
+		{Field: "Currency", Da: "Valuta", En: "Currency"},
+		{Field: "TotalExclVat", Da: "Beløb ekskl. moms", En: "Total excl. VAT", Amount: true},
+		{Field: "TotalInclVat", Da: "Beløb inkl. moms", En: "Total incl. VAT", Amount: true},
+		{Field: "ExternalReference", Da: "Ekstern reference", En: "External reference"},
+		{Field: "ContactGuid", Da: "Kontakt-ID", En: "Contact ID"},
+		{Field: "Guid", Da: "Faktura-ID", En: "Invoice ID"},
+		{Field: "CreatedAt", Da: "Oprettet", En: "Created"},
+		{Field: "UpdatedAt", Da: "Opdateret", En: "Updated"},
+	},
+	"creditnotes": {
+		{Field: "Number", Da: "Nummer", En: "Number"},
+		{Field: "Date", Da: "Dato", En: "Date"},
+		{Field: "ContactName", Da: "Kunde", En: "Customer"},
+		{Field: "Description", Da: "Beskrivelse", En: "Description"},
+		{Field: "Status", Da: "Status", En: "Status"},
+		{Field: "Currency", Da: "Valuta", En: "Currency"},
+		{Field: "TotalExclVat", Da: "Beløb ekskl. moms", En: "Total excl. VAT", Amount: true},
+		{Field: "TotalInclVat", Da: "Beløb inkl. moms", En: "Total incl. VAT", Amount: true},
+		{Field: "CreditNoteFor", Da: "Krediterer faktura", En: "Credit note for"},
+		{Field: "ContactGuid", Da: "Kontakt-ID", En: "Contact ID"},
+		{Field: "Guid", Da: "Kreditnota-ID", En: "Credit note ID"},
+		{Field: "CreatedAt", Da: "Oprettet", En: "Created"},
+		{Field: "UpdatedAt", Da: "Opdateret", En: "Updated"},
+	},
+	"contacts": {
+		{Field: "Name", Da: "Navn", En: "Name"},
+		{Field: "IsPerson", Da: "Privatperson", En: "Person"},
+		{Field: "AttPerson", Da: "Att. person", En: "Attention"},
+		{Field: "Street", Da: "Adresse", En: "Street"},
+		{Field: "ZipCode", Da: "Postnr", En: "Zip code"},
+		{Field: "City", Da: "By", En: "City"},
+		{Field: "CountryKey", Da: "Land", En: "Country"},
+		{Field: "Phone", Da: "Telefon", En: "Phone"},
+		{Field: "Email", Da: "Email", En: "Email"},
+		{Field: "Webpage", Da: "Hjemmeside", En: "Website"},
+		{Field: "VatNumber", Da: "CVR/VAT", En: "VAT number"},
+		{Field: "EanNumber", Da: "EAN", En: "EAN"},
+		{Field: "PaymentConditionType", Da: "Betalingsbetingelse", En: "Payment terms"},
+		{Field: "PaymentConditionNumberOfDays", Da: "Betalingsdage", En: "Payment days"},
+		{Field: "ExternalReference", Da: "Ekstern reference", En: "External reference"},
+		{Field: "ContactGuid", Da: "Kontakt-ID", En: "Contact ID"},
+		{Field: "CreatedAt", Da: "Oprettet", En: "Created"},
+		{Field: "UpdatedAt", Da: "Opdateret", En: "Updated"},
+	},
+}
+
+// RecordsToCSV converts JSON objects of a resource to CSV. Only fields present in the records
+// become columns, so the CSV follows the configured field selection. Dates (fields ending in
+// Date) and amounts use the dialect's formats; timestamps are kept as they are.
+func RecordsToCSV(resource string, records []map[string]any, dialect *CSVDialect) ([]byte, error) {
+	present := make(map[string]bool)
+	for _, record := range records {
+		for field := range record {
+			present[field] = true
+		}
+	}
+
+	var columns []resourceColumn
+	known := make(map[string]bool)
+	for _, column := range resourceColumns[resource] {
+		known[column.Field] = true
+		if present[column.Field] {
+			columns = append(columns, column)
+		}
+	}
+	var extra []string
+	for field := range present {
+		// Deleted records are left out, so DeletedAt is always empty
+		if !known[field] && field != "DeletedAt" {
+			extra = append(extra, field)
+		}
+	}
+	sort.Strings(extra)
+	for _, field := range extra {
+		columns = append(columns, resourceColumn{Field: field, Da: field, En: field})
+	}
+
+	header := make([]string, len(columns))
+	for i, column := range columns {
+		header[i] = column.Da
+		if dialect.Language == "en" {
+			header[i] = column.En
+		}
+	}
+	rows := [][]string{header}
+
+	for _, record := range records {
+		row := make([]string, len(columns))
+		for i, column := range columns {
+			row[i] = formatCSVValue(column, record[column.Field], dialect)
+		}
+		rows = append(rows, row)
+	}
+
+	return dialect.write(rows)
+}
+
+func formatCSVValue(column resourceColumn, value any, dialect *CSVDialect) string {
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case string:
+		if strings.HasSuffix(column.Field, "Date") {
+			return dialect.FormatDate(v)
+		}
+		return v
+	case json.Number:
+		// Drafts have number 0 until they're booked
+		if column.Field == "Number" && v.String() == "0" {
+			return ""
+		}
+		if column.Amount {
+			if amount, err := ParseMoney(v.String()); err == nil {
+				return dialect.FormatNumber(amount)
+			}
+		}
+		return strings.Replace(v.String(), ".", dialect.DecimalSeparator, 1)
+	case bool:
+		if dialect.Language == "en" {
+			if v {
+				return "Yes"
+			}
+			return "No"
+		}
+		if v {
+			return "Ja"
+		}
+		return "Nej"
+	default:
+		data, _ := json.Marshal(v)
+		return string(data)
+	}
+}
+
+// FileIndexToCSV converts the file index to CSV, one row per file with the voucher it belongs to
+func FileIndexToCSV(index []FileIndexEntry, dialect *CSVDialect) ([]byte, error) {
+	header := []string{"Filnavn", "Status", "Uploadet", "Størrelse", "Regnskabsår", "Bilagstype", "Bilag", "Placering", "SHA-256", "Fil-ID"}
+	if dialect.Language == "en" {
+		header = []string{"File name", "Status", "Uploaded", "Size", "Accounting year", "Voucher type", "Voucher", "Location", "SHA-256", "File ID"}
+	}
+	rows := [][]string{header}
+
+	for _, entry := range index {
+		year, voucherType, voucher := "", "", ""
+		if entry.Voucher != nil {
+			year = entry.Voucher.Year
+			voucherType = VoucherTypeName(&entry.Voucher.VoucherType, "")
+			if dialect.Language == "en" {
+				voucherType = voucherTypeNameEnglish(&entry.Voucher.VoucherType, "")
+			}
+			voucher = fmt.Sprintf("%d", entry.Voucher.VoucherNumber)
+		}
+		location := entry.StoredName
+		if entry.VoucherPath != "" {
+			location = entry.VoucherPath
+		}
+
+		rows = append(rows, []string{
+			entry.OriginalName,
+			entry.Status,
+			entry.UploadedAt,
+			fmt.Sprintf("%d", entry.Size),
+			year,
+			voucherType,
+			voucher,
+			location,
+			entry.SHA256,
+			entry.FileGuid,
+		})
+	}
+
+	return dialect.write(rows)
+}
+
+// latestSnapshotRecords merges the snapshot files of a resource (e.g. invoices/invoices_*.json),
+// later files taking precedence, and leaves out records listed in the deleted snapshots or marked
+// deleted. Records are sorted by number.
+func latestSnapshotRecords(outDir, resource string) ([]map[string]any, error) {
+	latest := make(map[string]map[string]any)
+	if err := readSnapshotRecords(filepath.Join(outDir, resource, resource+"_*.json"), func(record map[string]any, guid string) {
+		latest[guid] = record
+	}); err != nil {
+		return nil, err
+	}
+	if err := readSnapshotRecords(filepath.Join(outDir, "deleted", resource, "deleted_"+resource+"_*.json"), func(record map[string]any, guid string) {
+		delete(latest, guid)
+	}); err != nil {
+		return nil, err
+	}
+
+	records := make([]map[string]any, 0, len(latest))
+	for _, record := range latest {
+		if deletedAt, _ := record["DeletedAt"].(string); deletedAt == "" {
+			records = append(records, record)
+		}
+	}
+	sort.Slice(records, func(i, j int) bool {
+		if a, b := recordNumber(records[i]), recordNumber(records[j]); a != b {
+			return a < b
+		}
+		return fmt.Sprint(records[i]["Guid"]) < fmt.Sprint(records[j]["Guid"])
+	})
+	return records, nil
+}
+
+// recordNumber returns a record's Number, or 0 for drafts without a number
+func recordNumber(record map[string]any) int64 {
+	number, _ := record["Number"].(json.Number)
+	n, _ := number.Int64()
+	return n
+}
+
+// readSnapshotRecords calls fn for every record in the snapshot files matching pattern, oldest file first
+func readSnapshotRecords(pattern string, fn func(record map[string]any, guid string)) error {
+	matches, err := filepath.Glob(pattern)
+	if err != nil {
+		return err
+	}
+	// Timestamps in the file names sort chronologically
+	sort.Strings(matches)
+
+	for _, match := range matches {
+		data, err := os.ReadFile(match)
+		if err != nil {
+			return err
+		}
+		var response struct {
+			Collection []map[string]any `json:"Collection"`
+		}
+		if err := decodeJSON(data, &response); err != nil {
+			return fmt.Errorf("failed to parse %s: %w", match, err)
+		}
+		for _, record := range response.Collection {
+			if guid, _ := record["Guid"].(string); guid != "" {
+				fn(record, guid)
+			}
+		}
+	}
+	return nil
+}
+
+// loadContactRecords reads contacts/contacts.json without deleted contacts, sorted by name
+func loadContactRecords(outDir string) ([]map[string]any, error) {
+	data, err := os.ReadFile(filepath.Join(outDir, "contacts", "contacts.json"))
+	if err != nil {
+		return nil, err
+	}
+	var contacts []map[string]any
+	if err := decodeJSON(data, &contacts); err != nil {
+		return nil, fmt.Errorf("failed to parse contacts: %w", err)
+	}
+
+	records := make([]map[string]any, 0, len(contacts))
+	for _, contact := range contacts {
+		if deletedAt, _ := contact["DeletedAt"].(string); deletedAt == "" {
+			records = append(records, contact)
+		}
+	}
+	sort.SliceStable(records, func(i, j int) bool {
+		return fmt.Sprint(records[i]["Name"]) < fmt.Sprint(records[j]["Name"])
+	})
+	return records, nil
+}
+
+// decodeJSON decodes keeping numbers exact
+func decodeJSON(data []byte, v any) error {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	return decoder.Decode(v)
+}
+
+// saveSnapshotCSV writes a CSV of the current records of an invoice or credit note snapshot resource
+func saveSnapshotCSV(outDir, resource string, csvDialect *CSVDialect, dryRun bool) error {
+	filename := filepath.Join(outDir, resource, resource+".csv")
+	if dryRun {
+		log.Printf("[Dry Run] Would save CSV to %s", filename)
+		return nil
+	}
+
+	records, err := latestSnapshotRecords(outDir, resource)
+	if err != nil {
+		return err
+	}
+	csvData, err := RecordsToCSV(resource, records, csvDialect)
+	if err != nil {
+		return fmt.Errorf("failed to convert %s to CSV: %w", resource, err)
+	}
+	return os.WriteFile(filename, csvData, 0644)
+}
+
+// saveFileIndexCSV writes files/index.csv from the file index
+func saveFileIndexCSV(outDir string, index []FileIndexEntry, csvDialect *CSVDialect, dryRun bool) error {
+	filename := filepath.Join(outDir, "files", "index.csv")
+	if dryRun {
+		log.Printf("[Dry Run] Would save CSV to %s", filename)
+		return nil
+	}
+
+	csvData, err := FileIndexToCSV(index, csvDialect)
+	if err != nil {
+		return fmt.Errorf("failed to convert file index to CSV: %w", err)
+	}
+	return os.WriteFile(filename, csvData, 0644)
+}
+
+// saveContactsCSV writes contacts/contacts.csv from contacts/contacts.json
+func saveContactsCSV(outDir string, csvDialect *CSVDialect, dryRun bool) error {
+	filename := filepath.Join(outDir, "contacts", "contacts.csv")
+	if dryRun {
+		log.Printf("[Dry Run] Would save CSV to %s", filename)
+		return nil
+	}
+
+	records, err := loadContactRecords(outDir)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	csvData, err := RecordsToCSV("contacts", records, csvDialect)
+	if err != nil {
+		return fmt.Errorf("failed to convert contacts to CSV: %w", err)
+	}
+	return os.WriteFile(filename, csvData, 0644)
+}
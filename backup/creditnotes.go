@@ -13,7 +13,7 @@ import (
 	"github.com/rostved/dinero-backup/state"
 )
 
-func BackupCreditNotes(client *dinero.Client, stateManager *state.Manager, outDir string, dryRun bool) error {
+func BackupCreditNotes(client *dinero.Client, stateManager *state.Manager, outDir string, dryRun bool, selection *Selection, csvDialect *CSVDialect) error {
 	log.Println("Backing up Credit Notes...")
 
 	if !dryRun {
@@ -30,10 +30,19 @@ func BackupCreditNotes(client *dinero.Client, stateManager *state.Manager, outDi
 	hasData := false
 
 	params := url.Values{}
-	params.Set("changesSince", lastSync)
+	if selection != nil {
+		// Re-pull everything dated within the selection instead of changes since the last sync
+		log.Printf("Fetching credit notes dated %s (selective)", selection)
+		params.Set("startDate", selection.From.Format("2006-01-02"))
+		params.Set("endDate", selection.To.Format("2006-01-02"))
+	} else {
+		params.Set("changesSince", lastSync)
+	}
 
 	// Fetch Active Credit Notes
-	data, err := client.Get("/v1/{organizationId}/sales/creditnotes", params)
+	data, err := fetchDocumentPages(client, "/v1/{organizationId}/sales/creditnotes", params, func(page int, data []byte) error {
+		return validateResponse("creditnotes", data, nil)
+	})
 	if err != nil {
 		return err
 	}
@@ -58,7 +67,7 @@ func BackupCreditNotes(client *dinero.Client, stateManager *state.Manager, outDi
 
 	// Fetch Deleted Credit Notes
 	params.Set("deletedOnly", "true")
-	if deletedData, err := client.Get("/v1/{organizationId}/sales/creditnotes", params); err == nil {
+	if deletedData, err := fetchDocumentPages(client, "/v1/{organizationId}/sales/creditnotes", params, nil); err == nil {
 		var deletedResponse PaginatedResponse
 		if err := json.Unmarshal(deletedData, &deletedResponse); err == nil && len(deletedResponse.Collection) > 0 {
 			hasData = true
@@ -74,6 +83,18 @@ func BackupCreditNotes(client *dinero.Client, stateManager *state.Manager, outDi
 		}
 	}
 
+	// The CSV holds the latest version of every credit note, so it's rewritten even without changes
+	if csvDialect != nil {
+		if err := saveSnapshotCSV(outDir, "creditnotes", csvDialect, dryRun); err != nil {
+			return err
+		}
+	}
+
+	// A selective run doesn't move the incremental cursor
+	if selection != nil {
+		return nil
+	}
+
 	// Only update lastSync if we got data back (endpoint might be unstable)
 	if hasData && !dryRun {
 		stateManager.UpdateCreditNotes(now)
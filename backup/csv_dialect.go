@@ -0,0 +1,265 @@
+package backup
+
+import (
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// CSVDialect controls how CSV files are formatted
+type CSVDialect struct {
+	Name              string
+	Delimiter         rune
+	DecimalSeparator  string
+	ThousandSeparator string
+	// DateFormat uses YYYY, MM and DD, e.g. DD-MM-YYYY
+	DateFormat string
+	// Language of the header and voucher type labels: "da" or "en"
+	Language string
+	BOM      bool
+	// Columns lists the entry columns to include, in order
+	Columns []string
+}
+
+// csvDialects are the predefined dialects. Danish matches Dinero's own export and opens directly
+// in a Danish Excel; english suits English-language spreadsheets; plain is for data pipelines.
+var csvDialects = map[string]CSVDialect{
+	"danish": {
+		Delimiter: ';', DecimalSeparator: ",", ThousandSeparator: ".",
+		DateFormat: "YYYY-MM-DD", Language: "da", BOM: true,
+	},
+	"english": {
+		Delimiter: ',', DecimalSeparator: ".", ThousandSeparator: ",",
+		DateFormat: "YYYY-MM-DD", Language: "en", BOM: true,
+	},
+	"plain": {
+		Delimiter: ',', DecimalSeparator: ".", ThousandSeparator: "",
+		DateFormat: "YYYY-MM-DD", Language: "en", BOM: false,
+	},
+}
+
+// DefaultCSVDialect is used when no dialect is configured
+const DefaultCSVDialect = "danish"
+
+// entryColumns are the entry columns available in CSV files, with their headers per language
+var entryColumns = map[string]map[string]string{
+	"account":      {"da": "Konto", "en": "Account"},
+	"account_name": {"da": "Kontonavn", "en": "Account name"},
+	"date":         {"da": "Dato", "en": "Date"},
+	"voucher":      {"da": "Bilag", "en": "Voucher"},
+	"voucher_type": {"da": "Bilagstype", "en": "Voucher type"},
+	"description":  {"da": "Tekst", "en": "Description"},
+	"vat_type":     {"da": "Momstype", "en": "VAT type"},
+	"vat_code":     {"da": "Momskode", "en": "VAT code"},
+	"amount":       {"da": "Beløb", "en": "Amount"},
+	"balance":      {"da": "Saldo", "en": "Balance"},
+	"entry_guid":   {"da": "Posterings-ID", "en": "Entry ID"},
+	"contact_guid": {"da": "Kontakt-ID", "en": "Contact ID"},
+}
+
+// defaultEntryColumns match the columns of Dinero's entry export
+var defaultEntryColumns = []string{"account", "account_name", "date", "voucher", "voucher_type", "description", "vat_type", "amount", "balance"}
+
+// LoadCSVDialect resolves the CSV dialect from the environment: CSV_DIALECT selects a predefined
+// dialect (danish, english or plain), and CSV_DELIMITER, CSV_DECIMAL_SEPARATOR,
+// CSV_THOUSAND_SEPARATOR, CSV_DATE_FORMAT, CSV_HEADER_LANGUAGE, CSV_BOM and CSV_COLUMNS
+// override its settings.
+func LoadCSVDialect(getenv func(string) string) (*CSVDialect, error) {
+	name := strings.ToLower(strings.TrimSpace(getenv("CSV_DIALECT")))
+	if name == "" {
+		name = DefaultCSVDialect
+	}
+	preset, ok := csvDialects[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown CSV_DIALECT %q (known: %s)", name, strings.Join(csvDialectNames(), ", "))
+	}
+	dialect := preset
+	dialect.Name = name
+	dialect.Columns = defaultEntryColumns
+
+	if value := getenv("CSV_DELIMITER"); value != "" {
+		delimiter, err := parseDelimiter(value)
+		if err != nil {
+			return nil, err
+		}
+		dialect.Delimiter = delimiter
+	}
+	if value, ok := lookupSeparator(getenv, "CSV_DECIMAL_SEPARATOR"); ok {
+		dialect.DecimalSeparator = value
+	}
+	if value, ok := lookupSeparator(getenv, "CSV_THOUSAND_SEPARATOR"); ok {
+		dialect.ThousandSeparator = value
+	}
+	if value := getenv("CSV_DATE_FORMAT"); value != "" {
+		dialect.DateFormat = value
+	}
+	if value := getenv("CSV_HEADER_LANGUAGE"); value != "" {
+		dialect.Language = strings.ToLower(value)
+	}
+	if value := getenv("CSV_BOM"); value != "" {
+		bom, err := strconv.ParseBool(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid CSV_BOM %q: use true or false", value)
+		}
+		dialect.BOM = bom
+	}
+	if value := getenv("CSV_COLUMNS"); value != "" {
+		dialect.Columns = nil
+		for _, column := range strings.Split(value, ",") {
+			if column = strings.ToLower(strings.TrimSpace(column)); column != "" {
+				dialect.Columns = append(dialect.Columns, column)
+			}
+		}
+	}
+
+	if err := dialect.Validate(); err != nil {
+		return nil, err
+	}
+	return &dialect, nil
+}
+
+// Validate checks that the dialect produces readable files
+func (d CSVDialect) Validate() error {
+	if d.Delimiter == '"' || d.Delimiter == '\r' || d.Delimiter == '\n' || d.Delimiter == utf8.RuneError {
+		return fmt.Errorf("invalid CSV delimiter %q", d.Delimiter)
+	}
+	if d.DecimalSeparator == "" {
+		return fmt.Errorf("CSV decimal separator can't be empty")
+	}
+	if d.DecimalSeparator == d.ThousandSeparator {
+		return fmt.Errorf("CSV decimal and thousand separators must differ")
+	}
+	if _, err := dateLayout(d.DateFormat); err != nil {
+		return err
+	}
+	if d.Language != "da" && d.Language != "en" {
+		return fmt.Errorf("unknown CSV header language %q (known: da, en)", d.Language)
+	}
+	if len(d.Columns) == 0 {
+		return fmt.Errorf("no CSV columns selected")
+	}
+	for _, column := range d.Columns {
+		if _, ok := entryColumns[column]; !ok {
+			return fmt.Errorf("unknown CSV column %q (known: %s)", column, strings.Join(sortedColumnNames(), ", "))
+		}
+	}
+	return nil
+}
+
+// FormatNumber formats an amount with the dialect's decimal and thousand separators
+func (d CSVDialect) FormatNumber(n Money) string {
+	parts := strings.Split(n.String(), ".")
+	intPart, decPart := parts[0], parts[1]
+
+	negative := strings.HasPrefix(intPart, "-")
+	intPart = strings.TrimPrefix(intPart, "-")
+
+	var result strings.Builder
+	if negative {
+		result.WriteByte('-')
+	}
+	for i, c := range intPart {
+		if i > 0 && (len(intPart)-i)%3 == 0 {
+			result.WriteString(d.ThousandSeparator)
+		}
+		result.WriteRune(c)
+	}
+	result.WriteString(d.DecimalSeparator)
+	result.WriteString(decPart)
+	return result.String()
+}
+
+// FormatDate formats a date (YYYY-MM-DD, optionally followed by a time) with the dialect's date
+// format. Values that aren't dates are returned unchanged.
+func (d CSVDialect) FormatDate(date string) string {
+	if len(date) < 10 {
+		return date
+	}
+	t, err := time.Parse("2006-01-02", date[:10])
+	if err != nil {
+		return date
+	}
+	layout, _ := dateLayout(d.DateFormat)
+	return t.Format(layout)
+}
+
+// header returns the header of a column in the dialect's language
+func (d CSVDialect) header(column string) string {
+	return entryColumns[column][d.Language]
+}
+
+// write encodes rows as CSV with the dialect's delimiter and BOM. Lines end with CRLF, which
+// both Excel and CSV parsers expect.
+func (d CSVDialect) write(rows [][]string) ([]byte, error) {
+	var buf bytes.Buffer
+	if d.BOM {
+		buf.Write([]byte{0xEF, 0xBB, 0xBF})
+	}
+
+	w := csv.NewWriter(&buf)
+	w.Comma = d.Delimiter
+	w.UseCRLF = true
+	if err := w.WriteAll(rows); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// dateLayout converts a date format with YYYY, MM and DD to a Go time layout
+func dateLayout(format string) (string, error) {
+	if !strings.Contains(format, "YYYY") || !strings.Contains(format, "MM") || !strings.Contains(format, "DD") {
+		return "", fmt.Errorf("invalid CSV date format %q: must contain YYYY, MM and DD", format)
+	}
+	rest := strings.NewReplacer("YYYY", "", "MM", "", "DD", "").Replace(format)
+	if strings.Trim(rest, "-./ ") != "" {
+		return "", fmt.Errorf("invalid CSV date format %q: only YYYY, MM, DD and the separators - . / and space are allowed", format)
+	}
+	return strings.NewReplacer("YYYY", "2006", "MM", "01", "DD", "02").Replace(format), nil
+}
+
+// parseDelimiter accepts a single character, or "tab"
+func parseDelimiter(value string) (rune, error) {
+	if strings.EqualFold(value, "tab") || value == `\t` {
+		return '\t', nil
+	}
+	if utf8.RuneCountInString(value) != 1 {
+		return 0, fmt.Errorf("invalid CSV_DELIMITER %q: must be a single character or \"tab\"", value)
+	}
+	r, _ := utf8.DecodeRuneInString(value)
+	return r, nil
+}
+
+// lookupSeparator reads a separator; "none" selects no separator, e.g. for thousands
+func lookupSeparator(getenv func(string) string, key string) (string, bool) {
+	value := getenv(key)
+	if value == "" {
+		return "", false
+	}
+	if strings.EqualFold(value, "none") {
+		return "", true
+	}
+	return value, true
+}
+
+func csvDialectNames() []string {
+	names := make([]string, 0, len(csvDialects))
+	for name := range csvDialects {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func sortedColumnNames() []string {
+	names := make([]string, 0, len(entryColumns))
+	for name := range entryColumns {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,203 @@
+package backup
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/rostved/dinero-backup/dinero"
+)
+
+// DocumentMeta is the metadata sidecar stored next to each downloaded document as <file>.meta.json
+type DocumentMeta struct {
+	Source       string `json:"Source"`
+	Guid         string `json:"Guid"`
+	OriginalName string `json:"OriginalName,omitempty"`
+	DownloadedAt string `json:"DownloadedAt"`
+	ContentType  string `json:"ContentType"`
+	Size         int64  `json:"Size"`
+	SHA256       string `json:"SHA256"`
+	// SourceUpdatedAt is the UpdatedAt of the Dinero resource the document was downloaded for
+	SourceUpdatedAt string `json:"SourceUpdatedAt,omitempty"`
+	// PreviousSHA256 lists checksums of earlier downloads whose content differed
+	PreviousSHA256 []string `json:"PreviousSHA256,omitempty"`
+	// Versions lists earlier versions kept next to the document, oldest first
+	Versions []string `json:"Versions,omitempty"`
+}
+
+// metaPath returns the sidecar path for a downloaded document
+func metaPath(path string) string {
+	return path + ".meta.json"
+}
+
+// loadDocumentMeta reads the sidecar for a downloaded document
+func loadDocumentMeta(path string) (*DocumentMeta, error) {
+	data, err := os.ReadFile(metaPath(path))
+	if err != nil {
+		return nil, err
+	}
+
+	var meta DocumentMeta
+	if err := json.Unmarshal(data, &meta); err != nil {
+		return nil, err
+	}
+	return &meta, nil
+}
+
+func saveDocumentMeta(path string, meta *DocumentMeta) error {
+	data, err := json.MarshalIndent(meta, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(metaPath(path), data, 0644)
+}
+
+// downloadVersionedDocument downloads a document that may change over time, e.g. an invoice PDF
+// or a voucher file that is downloaded again. If the content is identical to the document on disk,
+// the file is left untouched. If it differs, the current file and its sidecar are kept as
+// <name>.v<N><ext> before the new version is written, so every version ever downloaded is preserved.
+// Returns the sidecar of the document on disk and true if a new version was written.
+func downloadVersionedDocument(download *dinero.Download, source, guid, originalName, sourceUpdatedAt, path string) (*DocumentMeta, bool, error) {
+	tmpPath, meta, err := writeDownload(download, source, guid, originalName, path)
+	if err != nil {
+		return nil, false, err
+	}
+	meta.SourceUpdatedAt = sourceUpdatedAt
+
+	if _, err := os.Stat(path); err == nil {
+		previous, err := ensureDocumentMeta(source, guid, originalName, path)
+		if err != nil {
+			os.Remove(tmpPath)
+			return nil, false, err
+		}
+
+		if previous.SHA256 == meta.SHA256 {
+			// Same content, only record that this version has been checked
+			os.Remove(tmpPath)
+			previous.SourceUpdatedAt = sourceUpdatedAt
+			return previous, false, saveDocumentMeta(path, previous)
+		}
+
+		versionPath, err := archiveVersion(path)
+		if err != nil {
+			os.Remove(tmpPath)
+			return nil, false, err
+		}
+		log.Printf("Content changed for %s, previous version kept as %s", path, filepath.Base(versionPath))
+
+		meta.PreviousSHA256 = append(previous.PreviousSHA256, previous.SHA256)
+		meta.Versions = append(previous.Versions, filepath.Base(versionPath))
+	} else if previous, err := loadDocumentMeta(path); err == nil {
+		// The document was removed locally, but its sidecar still holds its history
+		meta.PreviousSHA256 = previous.PreviousSHA256
+		meta.Versions = previous.Versions
+		if previous.SHA256 != "" && previous.SHA256 != meta.SHA256 {
+			log.Printf("Content changed for %s (was %s, now %s)", path, previous.SHA256, meta.SHA256)
+			meta.PreviousSHA256 = append(meta.PreviousSHA256, previous.SHA256)
+		}
+	}
+
+	if err := os.Rename(tmpPath, path); err != nil {
+		return nil, false, err
+	}
+	if err := saveDocumentMeta(path, meta); err != nil {
+		return nil, false, err
+	}
+	return meta, true, nil
+}
+
+// archiveVersion moves a document and its sidecar to the next free <name>.v<N><ext> path
+func archiveVersion(path string) (string, error) {
+	ext := filepath.Ext(path)
+	base := strings.TrimSuffix(path, ext)
+
+	for n := 1; ; n++ {
+		versionPath := fmt.Sprintf("%s.v%d%s", base, n, ext)
+		if _, err := os.Stat(versionPath); err == nil {
+			continue
+		}
+		if err := os.Rename(path, versionPath); err != nil {
+			return "", err
+		}
+		if err := os.Rename(metaPath(path), metaPath(versionPath)); err != nil && !os.IsNotExist(err) {
+			return "", err
+		}
+		return versionPath, nil
+	}
+}
+
+// writeDownload streams a download to a temporary file next to path, computing its size and checksum.
+// Writing to a temporary file first means a failed download never leaves a partial file behind.
+func writeDownload(download *dinero.Download, source, guid, originalName, path string) (string, *DocumentMeta, error) {
+	defer download.Body.Close()
+
+	tmpPath := path + ".tmp"
+	outFile, err := os.Create(tmpPath)
+	if err != nil {
+		return "", nil, err
+	}
+
+	hash := sha256.New()
+	size, err := io.Copy(io.MultiWriter(outFile, hash), download.Body)
+	outFile.Close()
+	if err != nil {
+		os.Remove(tmpPath)
+		return "", nil, err
+	}
+
+	meta := &DocumentMeta{
+		Source:       source,
+		Guid:         guid,
+		OriginalName: originalName,
+		DownloadedAt: time.Now().UTC().Format(time.RFC3339),
+		ContentType:  download.ContentType,
+		Size:         size,
+		SHA256:       hex.EncodeToString(hash.Sum(nil)),
+	}
+	return tmpPath, meta, nil
+}
+
+// ensureDocumentMeta writes a sidecar for a document downloaded before sidecars existed,
+// computing size and checksum from the file on disk
+func ensureDocumentMeta(source, guid, originalName, path string) (*DocumentMeta, error) {
+	if meta, err := loadDocumentMeta(path); err == nil && meta.Source != "" {
+		return meta, nil
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	hash := sha256.New()
+	size, err := io.Copy(hash, f)
+	if err != nil {
+		return nil, err
+	}
+
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	meta := &DocumentMeta{
+		Source:       source,
+		Guid:         guid,
+		OriginalName: originalName,
+		DownloadedAt: info.ModTime().UTC().Format(time.RFC3339),
+		Size:         size,
+		SHA256:       hex.EncodeToString(hash.Sum(nil)),
+	}
+	if err := saveDocumentMeta(path, meta); err != nil {
+		return nil, err
+	}
+	return meta, nil
+}
@@ -7,13 +7,14 @@ import (
 	"net/url"
 	"os"
 	"path/filepath"
+	"sort"
 	"time"
 
 	"github.com/rostved/dinero-backup/dinero"
 	"github.com/rostved/dinero-backup/state"
 )
 
-func BackupEntries(client *dinero.Client, stateManager *state.Manager, outDir string, dryRun bool, csvOutput bool) error {
+func BackupEntries(client *dinero.Client, stateManager *state.Manager, outDir string, dryRun bool, csvDialect *CSVDialect, reconcile ReconcileOptions, selection *Selection) error {
 	log.Println("Backing up Entries...")
 
 	if !dryRun {
@@ -33,62 +34,94 @@ func BackupEntries(client *dinero.Client, stateManager *state.Manager, outDir st
 		return nil
 	}
 
+	// A selective run re-pulls the selected period without touching the incremental state
+	if selection != nil {
+		return fetchSelectedEntries(client, stateManager, outDir, selectYears(years, selection), selection, dryRun, csvDialect)
+	}
+
 	// Separate years into initialized and uninitialized
-	var uninitializedYears []time.Time
-	var initializedYears []time.Time
+	var uninitializedYears []FiscalYear
+	var initializedYears []FiscalYear
 	for _, year := range years {
-		if stateManager.IsEntryYearInitialized(year.Year()) {
+		if isEntryYearInitialized(stateManager, year) {
 			initializedYears = append(initializedYears, year)
 		} else {
 			uninitializedYears = append(uninitializedYears, year)
 		}
 	}
 
+	// Changes are fetched from the last sync before this run; fetching a full year below advances it,
+	// which would otherwise skip changes to the years that were already initialized
+	lastSync := stateManager.GetLastSyncEntries()
+
 	// Process uninitialized years - fetch full entries including primo
 	for _, year := range uninitializedYears {
-		if err := fetchFullYear(client, stateManager, outDir, year, dryRun, csvOutput); err != nil {
-			log.Printf("Error fetching entries for year %d: %v", year.Year(), err)
+		if err := fetchFullYear(client, stateManager, outDir, year, dryRun, csvDialect); err != nil {
+			log.Printf("Error fetching entries for year %s: %v", year.Name, err)
 			continue
 		}
 	}
 
 	// Process initialized years - fetch changes once and merge into each year
 	if len(initializedYears) > 0 {
-		if err := fetchAndMergeAllChanges(client, stateManager, outDir, initializedYears, dryRun, csvOutput); err != nil {
+		if err := fetchAndMergeAllChanges(client, stateManager, outDir, years, lastSync, dryRun, csvDialect); err != nil {
 			return fmt.Errorf("error fetching entry changes: %w", err)
 		}
 	}
 
+	// Full reconciliation of every initialized year with a drift report
+	var reconcileErrs []error
+	if reconcile.Enabled {
+		for _, year := range initializedYears {
+			if err := reconcileYearWithReport(client, stateManager, outDir, year, dryRun, csvDialect, reconcile.Replace); err != nil {
+				log.Printf("Error reconciling entries for year %s: %v", year.Name, err)
+				reconcileErrs = append(reconcileErrs, err)
+			}
+		}
+	}
+
+	// Periodically compare initialized years against a full fetch to detect deleted entries,
+	// which /entries/changes doesn't report. A replacing reconciliation above already counts
+	// as the check; a report-only one doesn't tombstone anything, so the check still runs.
+	for _, year := range initializedYears {
+		if !isReconcileDue(stateManager, year) {
+			continue
+		}
+		if err := reconcileDeletedEntries(client, stateManager, outDir, year, dryRun, csvDialect); err != nil {
+			log.Printf("Error reconciling entries for year %s: %v", year.Name, err)
+		}
+	}
+
+	if len(reconcileErrs) > 0 {
+		return fmt.Errorf("reconciliation failed for %d year(s)", len(reconcileErrs))
+	}
 	return nil
 }
 
-// fetchFullYear fetches all entries for a year using /entries endpoint (includes primo)
-func fetchFullYear(client *dinero.Client, stateManager *state.Manager, outDir string, year time.Time, dryRun bool, csvOutput bool) error {
-	yearNum := year.Year()
-	fromDate := time.Date(yearNum, 1, 1, 0, 0, 0, 0, time.UTC)
-	toDate := time.Date(yearNum, 12, 31, 0, 0, 0, 0, time.UTC)
-
-	log.Printf("Fetching full entries for year %d (first run, includes primo)", yearNum)
+// isEntryYearInitialized checks whether a year's entries have been fetched in full.
+// State from before fiscal year support tracked calendar years, which only match calendar accounting years.
+func isEntryYearInitialized(stateManager *state.Manager, year FiscalYear) bool {
+	if stateManager.IsEntryYearInitialized(year.Name) {
+		return true
+	}
+	return year.IsCalendarYear() && stateManager.IsLegacyEntryYearInitialized(year.From.Year())
+}
 
-	params := url.Values{}
-	params.Set("fromDate", fromDate.Format("2006-01-02"))
-	params.Set("toDate", toDate.Format("2006-01-02"))
+// fetchFullYear fetches all entries for an accounting year using /entries endpoint (includes primo)
+func fetchFullYear(client *dinero.Client, stateManager *state.Manager, outDir string, year FiscalYear, dryRun bool, csvDialect *CSVDialect) error {
+	log.Printf("Fetching full entries for year %s (%s to %s, first run, includes primo)",
+		year.Name, year.From.Format("2006-01-02"), year.To.Format("2006-01-02"))
 
-	data, err := client.Get("/v1/{organizationId}/entries", params)
+	entries, err := fetchYearEntries(client, year)
 	if err != nil {
-		return fmt.Errorf("failed to fetch entries: %w", err)
-	}
-
-	var entries []Entry
-	if err := json.Unmarshal(data, &entries); err != nil {
-		return fmt.Errorf("failed to parse entries: %w", err)
+		return err
 	}
 
 	if len(entries) == 0 {
-		log.Printf("No entries found for year %d.", yearNum)
+		log.Printf("No entries found for year %s.", year.Name)
 		// Still mark as initialized even if empty
 		if !dryRun {
-			stateManager.MarkEntryYearInitialized(yearNum)
+			stateManager.MarkEntryYearInitialized(year.Name)
 			stateManager.UpdateEntries(time.Now().UTC().Format(time.RFC3339))
 			if err := stateManager.Save(); err != nil {
 				return err
@@ -98,15 +131,17 @@ func fetchFullYear(client *dinero.Client, stateManager *state.Manager, outDir st
 	}
 
 	// Save to file
-	if err := saveEntriesFile(outDir, yearNum, entries, csvOutput, dryRun); err != nil {
+	if err := saveEntriesFile(outDir, year, entries, csvDialect, dryRun); err != nil {
 		return err
 	}
 
-	log.Printf("Saved %d entries for year %d.", len(entries), yearNum)
+	log.Printf("Saved %d entries for year %s.", len(entries), year.Name)
 
 	if !dryRun {
-		stateManager.MarkEntryYearInitialized(yearNum)
-		stateManager.UpdateEntries(time.Now().UTC().Format(time.RFC3339))
+		now := time.Now().UTC().Format(time.RFC3339)
+		stateManager.MarkEntryYearInitialized(year.Name)
+		stateManager.UpdateEntries(now)
+		stateManager.UpdateEntriesReconciled(year.Name, now)
 		if err := stateManager.Save(); err != nil {
 			return err
 		}
@@ -115,10 +150,77 @@ func fetchFullYear(client *dinero.Client, stateManager *state.Manager, outDir st
 	return nil
 }
 
-// fetchAndMergeAllChanges fetches all changes once and merges them into the appropriate year files
-func fetchAndMergeAllChanges(client *dinero.Client, stateManager *state.Manager, outDir string, years []time.Time, dryRun bool, csvOutput bool) error {
-	lastSyncStr := stateManager.GetLastSyncEntries()
+// fetchSelectedEntries fetches the selected period of each accounting year via /entries and
+// merges it into the year's file. Entries outside the period and the sync state are left as they are.
+// Years that haven't been fetched in full are skipped, as a partial file would pass for the whole
+// year in the exports until the next normal run.
+func fetchSelectedEntries(client *dinero.Client, stateManager *state.Manager, outDir string, years []FiscalYear, selection *Selection, dryRun bool, csvDialect *CSVDialect) error {
+	if len(years) == 0 {
+		log.Printf("No accounting years overlap %s.", selection)
+		return nil
+	}
+
+	for _, year := range years {
+		if !isEntryYearInitialized(stateManager, year) {
+			log.Printf("Skipping entries for year %s: not fetched in full yet, run without --year, --from and --to first.", year.Name)
+			continue
+		}
+
+		from, to := selection.clip(year)
+		log.Printf("Fetching entries for year %s (%s to %s, selective)", year.Name, from.Format("2006-01-02"), to.Format("2006-01-02"))
+
+		entries, err := fetchEntryRange(client, from, to)
+		if err != nil {
+			return fmt.Errorf("failed to fetch entries for year %s: %w", year.Name, err)
+		}
+
+		existing, err := loadExistingEntries(outDir, year)
+		if err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to read entries for year %s: %w", year.Name, err)
+		}
+		merged := mergeEntries(existing, entries)
+
+		if err := saveEntriesFile(outDir, year, merged, csvDialect, dryRun); err != nil {
+			return err
+		}
+		log.Printf("Merged %d entries into year %s (total: %d entries).", len(entries), year.Name, len(merged))
+	}
+
+	return nil
+}
+
+// fetchYearEntries fetches all entries for an accounting year from the /entries endpoint
+func fetchYearEntries(client *dinero.Client, year FiscalYear) ([]RawEntry, error) {
+	return fetchEntryRange(client, year.From, year.To)
+}
+
+// fetchEntryRange fetches all entries dated within a period from the /entries endpoint
+func fetchEntryRange(client *dinero.Client, from, to time.Time) ([]RawEntry, error) {
+	params := url.Values{}
+	params.Set("fromDate", from.Format("2006-01-02"))
+	params.Set("toDate", to.Format("2006-01-02"))
+
+	data, err := client.Get("/v1/{organizationId}/entries", params)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch entries: %w", err)
+	}
+	if err := validateResponse("entries", data, nil); err != nil {
+		return nil, err
+	}
+
+	var entries []RawEntry
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return nil, fmt.Errorf("failed to parse entries: %w", err)
+	}
+
+	return entries, nil
+}
 
+// fetchAndMergeAllChanges fetches all changes once and merges them into the appropriate year files.
+// Changes are grouped over all accounting years; years that haven't been fetched in full are skipped,
+// as their changes are included when they are. Changes dated outside every known year are logged and
+// lastSync isn't advanced past them, so they are fetched again once their year exists.
+func fetchAndMergeAllChanges(client *dinero.Client, stateManager *state.Manager, outDir string, years []FiscalYear, lastSyncStr string, dryRun bool, csvDialect *CSVDialect) error {
 	lastSync, err := time.Parse(time.RFC3339, lastSyncStr)
 	if err != nil {
 		return fmt.Errorf("failed to parse lastSync time: %w", err)
@@ -129,8 +231,10 @@ func fetchAndMergeAllChanges(client *dinero.Client, stateManager *state.Manager,
 	log.Printf("Fetching entry changes from %s to %s", lastSync.Format(time.RFC3339), now.Format(time.RFC3339))
 
 	// API only allows 31 days at a time, so we need to chunk
-	var allChanges []Entry
+	var allChanges []RawEntry
 	chunkStart := lastSync
+	syncTo := now
+	outsideYears := 0
 
 	for chunkStart.Before(now) {
 		chunkEnd := chunkStart.AddDate(0, 0, 31)
@@ -148,12 +252,29 @@ func fetchAndMergeAllChanges(client *dinero.Client, stateManager *state.Manager,
 		if err != nil {
 			return fmt.Errorf("failed to fetch entry changes: %w", err)
 		}
+		if err := validateResponse("entries", data, nil); err != nil {
+			return err
+		}
 
-		var chunkChanges []Entry
+		var chunkChanges []RawEntry
 		if err := json.Unmarshal(data, &chunkChanges); err != nil {
 			return fmt.Errorf("failed to parse entry changes: %w", err)
 		}
 
+		for _, entry := range chunkChanges {
+			entryDate, err := time.Parse("2006-01-02", entry.Date)
+			if err == nil {
+				if _, ok := findFiscalYear(years, entryDate); ok {
+					continue
+				}
+			}
+			log.Printf("Entry %s dated %s is outside all known accounting years, skipping.", entry.EntryGuid, entry.Date)
+			outsideYears++
+			if chunkStart.Before(syncTo) {
+				syncTo = chunkStart
+			}
+		}
+
 		allChanges = append(allChanges, chunkChanges...)
 		chunkStart = chunkEnd
 	}
@@ -165,36 +286,45 @@ func fetchAndMergeAllChanges(client *dinero.Client, stateManager *state.Manager,
 
 	log.Printf("Found %d total entry changes.", len(allChanges))
 
-	// Group changes by year
-	changesByYear := make(map[int][]Entry)
+	// Group changes by the accounting year containing the entry date
+	changesByYear := make(map[string][]RawEntry)
 	for _, entry := range allChanges {
 		entryDate, err := time.Parse("2006-01-02", entry.Date)
 		if err != nil {
 			continue
 		}
-		yearNum := entryDate.Year()
-		changesByYear[yearNum] = append(changesByYear[yearNum], entry)
+		year, ok := findFiscalYear(years, entryDate)
+		if !ok {
+			continue
+		}
+		changesByYear[year.Name] = append(changesByYear[year.Name], entry)
 	}
 
 	// Process each year that has changes
 	for _, year := range years {
-		yearNum := year.Year()
-		yearChanges := changesByYear[yearNum]
+		yearChanges := changesByYear[year.Name]
+
+		if !isEntryYearInitialized(stateManager, year) {
+			if len(yearChanges) > 0 {
+				log.Printf("Skipping %d changes for year %s, its entries haven't been fetched in full yet.", len(yearChanges), year.Name)
+			}
+			continue
+		}
 
 		if len(yearChanges) == 0 {
-			log.Printf("No changes for year %d.", yearNum)
+			log.Printf("No changes for year %s.", year.Name)
 			continue
 		}
 
-		log.Printf("Found %d changes for year %d, merging...", len(yearChanges), yearNum)
+		log.Printf("Found %d changes for year %s, merging...", len(yearChanges), year.Name)
 
 		// Load existing entries
-		existingEntries, err := loadExistingEntries(outDir, yearNum)
+		existingEntries, err := loadExistingEntries(outDir, year)
 		if err != nil {
 			// If file doesn't exist, fetch full year
-			log.Printf("Could not load existing entries for year %d, fetching full year: %v", yearNum, err)
-			if err := fetchFullYear(client, stateManager, outDir, year, dryRun, csvOutput); err != nil {
-				log.Printf("Error fetching full year %d: %v", yearNum, err)
+			log.Printf("Could not load existing entries for year %s, fetching full year: %v", year.Name, err)
+			if err := fetchFullYear(client, stateManager, outDir, year, dryRun, csvDialect); err != nil {
+				log.Printf("Error fetching full year %s: %v", year.Name, err)
 			}
 			continue
 		}
@@ -203,16 +333,21 @@ func fetchAndMergeAllChanges(client *dinero.Client, stateManager *state.Manager,
 		mergedEntries := mergeEntries(existingEntries, yearChanges)
 
 		// Save merged entries
-		if err := saveEntriesFile(outDir, yearNum, mergedEntries, csvOutput, dryRun); err != nil {
-			log.Printf("Error saving year %d: %v", yearNum, err)
+		if err := saveEntriesFile(outDir, year, mergedEntries, csvDialect, dryRun); err != nil {
+			log.Printf("Error saving year %s: %v", year.Name, err)
 			continue
 		}
 
-		log.Printf("Merged %d changes into year %d (total: %d entries).", len(yearChanges), yearNum, len(mergedEntries))
+		log.Printf("Merged %d changes into year %s (total: %d entries).", len(yearChanges), year.Name, len(mergedEntries))
+	}
+
+	if outsideYears > 0 {
+		log.Printf("%d entry changes are outside all known accounting years, they will be fetched again from %s.",
+			outsideYears, syncTo.Format(time.RFC3339))
 	}
 
 	if !dryRun {
-		stateManager.UpdateEntries(now.Format(time.RFC3339))
+		stateManager.UpdateEntries(syncTo.Format(time.RFC3339))
 		if err := stateManager.Save(); err != nil {
 			return err
 		}
@@ -221,16 +356,21 @@ func fetchAndMergeAllChanges(client *dinero.Client, stateManager *state.Manager,
 	return nil
 }
 
+// entriesFilename returns the path of an accounting year's entries file with the given extension
+func entriesFilename(outDir string, year FiscalYear, ext string) string {
+	return filepath.Join(outDir, "entries", fmt.Sprintf("entries_%s.%s", year.FileName(), ext))
+}
+
 // loadExistingEntries loads entries from an existing JSON file
-func loadExistingEntries(outDir string, year int) ([]Entry, error) {
+func loadExistingEntries(outDir string, year FiscalYear) ([]RawEntry, error) {
 	// Always read from JSON file (source of truth)
-	filename := filepath.Join(outDir, "entries", fmt.Sprintf("entries_%d.json", year))
+	filename := entriesFilename(outDir, year, "json")
 	data, err := os.ReadFile(filename)
 	if err != nil {
 		return nil, err
 	}
 
-	var entries []Entry
+	var entries []RawEntry
 	if err := json.Unmarshal(data, &entries); err != nil {
 		return nil, err
 	}
@@ -239,19 +379,28 @@ func loadExistingEntries(outDir string, year int) ([]Entry, error) {
 }
 
 // mergeEntries merges changed entries into existing entries by EntryGuid
-// Preserves existing order and appends new entries at the end
-func mergeEntries(existing, changes []Entry) []Entry {
+// Preserves existing order and appends new entries at the end. Entries without a GUID (e.g. primo)
+// can't be matched, so they are kept, and a changed one is only appended if it isn't stored already.
+func mergeEntries(existing, changes []RawEntry) []RawEntry {
 	// Create map of changes by GUID for quick lookup
-	changeMap := make(map[string]Entry)
+	changeMap := make(map[string]RawEntry)
 	for _, e := range changes {
-		changeMap[e.EntryGuid] = e
+		if e.EntryGuid != "" {
+			changeMap[e.EntryGuid] = e
+		}
+	}
+	stored := make(map[string]bool)
+	for _, e := range existing {
+		if e.EntryGuid == "" {
+			stored[string(e.Raw)] = true
+		}
 	}
 
 	// Track which changes have been applied
 	applied := make(map[string]bool)
 
 	// Update existing entries in place, preserving order
-	result := make([]Entry, 0, len(existing)+len(changes))
+	result := make([]RawEntry, 0, len(existing)+len(changes))
 	for _, e := range existing {
 		if changed, ok := changeMap[e.EntryGuid]; ok {
 			result = append(result, changed)
@@ -263,8 +412,16 @@ func mergeEntries(existing, changes []Entry) []Entry {
 
 	// Append new entries that weren't updates to existing ones
 	for _, e := range changes {
+		if e.EntryGuid == "" {
+			if !stored[string(e.Raw)] {
+				stored[string(e.Raw)] = true
+				result = append(result, e)
+			}
+			continue
+		}
 		if !applied[e.EntryGuid] {
-			result = append(result, e)
+			applied[e.EntryGuid] = true
+			result = append(result, changeMap[e.EntryGuid])
 		}
 	}
 
@@ -272,31 +429,40 @@ func mergeEntries(existing, changes []Entry) []Entry {
 }
 
 // saveEntriesFile saves entries to a file in JSON and optionally CSV format
-func saveEntriesFile(outDir string, year int, entries []Entry, csvOutput bool, dryRun bool) error {
+func saveEntriesFile(outDir string, year FiscalYear, entries []RawEntry, csvDialect *CSVDialect, dryRun bool) error {
 	// Always save JSON as source of truth
 	jsonData, err := json.MarshalIndent(entries, "", "  ")
 	if err != nil {
 		return fmt.Errorf("failed to marshal entries: %w", err)
 	}
 
-	jsonFilename := filepath.Join(outDir, "entries", fmt.Sprintf("entries_%d.json", year))
+	jsonFilename := entriesFilename(outDir, year, "json")
 
 	if !dryRun {
 		if err := os.WriteFile(jsonFilename, jsonData, 0644); err != nil {
 			return err
 		}
+
+		// Keep every observed version of each entry, as the JSON file only holds the latest
+		recorded, err := recordEntryHistory(outDir, year, entries)
+		if err != nil {
+			return fmt.Errorf("failed to record entry history: %w", err)
+		}
+		if recorded > 0 {
+			log.Printf("Recorded %d new entry versions for year %s.", recorded, year.Name)
+		}
 	} else {
 		log.Printf("[Dry Run] Would save %d entries to %s", len(entries), jsonFilename)
 	}
 
 	// Optionally also save CSV
-	if csvOutput {
-		csvData, err := EntriesToCSV(jsonData)
+	if csvDialect != nil {
+		csvData, err := EntriesToCSV(jsonData, csvDialect)
 		if err != nil {
 			return fmt.Errorf("failed to convert to CSV: %w", err)
 		}
 
-		csvFilename := filepath.Join(outDir, "entries", fmt.Sprintf("entries_%d.csv", year))
+		csvFilename := entriesFilename(outDir, year, "csv")
 		if !dryRun {
 			if err := os.WriteFile(csvFilename, csvData, 0644); err != nil {
 				return err
@@ -309,35 +475,70 @@ func saveEntriesFile(outDir string, year int, entries []Entry, csvOutput bool, d
 	return nil
 }
 
-// GetAccountingYears fetches all accounting years and returns their start dates
-func GetAccountingYears(client *dinero.Client) ([]time.Time, error) {
+// GetAccountingYears fetches all accounting years with their actual boundaries, sorted by start date
+func GetAccountingYears(client *dinero.Client) ([]FiscalYear, error) {
 	data, err := client.Get("/v1/{organizationId}/accountingyears", nil)
 	if err != nil {
 		return nil, err
 	}
+	if err := validateResponse("accountingyears", data, nil); err != nil {
+		return nil, err
+	}
+
+	return ParseAccountingYears(data, client.Debug)
+}
 
+// ParseAccountingYears parses an /accountingyears response, as returned by the API or saved
+// in organization/accountingyears.json, into accounting years sorted by start date
+func ParseAccountingYears(data []byte, debug bool) ([]FiscalYear, error) {
 	var years []AccountingYear
 	if err := json.Unmarshal(data, &years); err != nil {
 		return nil, err
 	}
 
-	var result []time.Time
+	var result []FiscalYear
 	for _, year := range years {
-		dateStr := year.FromDate
-		if dateStr == "" {
-			dateStr = year.DateStart
-		}
-		if dateStr == "" {
-			continue
-		}
-
-		t, err := time.ParseInLocation("2006-01-02", dateStr, time.UTC)
+		fiscalYear, err := year.FiscalYear()
 		if err != nil {
+			if debug {
+				log.Printf("Skipping accounting year %+v: %v", year, err)
+			}
 			continue
 		}
-
-		result = append(result, t)
+		result = append(result, fiscalYear)
 	}
 
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].From.Before(result[j].From)
+	})
+
 	return result, nil
 }
+
+// LoadEntries returns the backed up entries of an accounting year, leaving out entries deleted in Dinero
+func LoadEntries(outDir string, year FiscalYear) ([]Entry, error) {
+	entries, err := LoadAllEntries(outDir, year)
+	if err != nil {
+		return nil, err
+	}
+	return activeEntries(entries), nil
+}
+
+// LoadAllEntries returns all backed up entries of an accounting year, including tombstoned entries
+func LoadAllEntries(outDir string, year FiscalYear) ([]Entry, error) {
+	data, err := os.ReadFile(EntriesPath(outDir, year))
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []Entry
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return nil, fmt.Errorf("failed to parse entries for year %s: %w", year.Name, err)
+	}
+	return entries, nil
+}
+
+// EntriesPath returns the path of an accounting year's entries JSON file
+func EntriesPath(outDir string, year FiscalYear) string {
+	return entriesFilename(outDir, year, "json")
+}
@@ -0,0 +1,214 @@
+package backup
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"time"
+)
+
+// EntryVersion is one observed version of an entry in the append-only history
+type EntryVersion struct {
+	SeenAt    string          `json:"SeenAt"`
+	Year      string          `json:"Year"`
+	EntryGuid string          `json:"EntryGuid"`
+	Entry     json.RawMessage `json:"Entry"`
+}
+
+// entryHistoryFilename returns the path of an accounting year's history file
+func entryHistoryFilename(outDir string, year FiscalYear) string {
+	return filepath.Join(outDir, "entries", "history", fmt.Sprintf("history_%s.jsonl", year.FileName()))
+}
+
+// recordEntryHistory appends every entry whose content differs from its last recorded
+// version to the year's history file (one JSON object per line). The first time an
+// entry is seen, its initial version is recorded.
+func recordEntryHistory(outDir string, year FiscalYear, entries []RawEntry) (int, error) {
+	filename := entryHistoryFilename(outDir, year)
+
+	latest, err := loadLatestVersions(filename)
+	if err != nil {
+		return 0, err
+	}
+
+	seenAt := time.Now().UTC().Format(time.RFC3339)
+	var buf bytes.Buffer
+	recorded := 0
+
+	for _, entry := range entries {
+		if entry.EntryGuid == "" {
+			continue
+		}
+		data, err := json.Marshal(entry)
+		if err != nil {
+			return 0, err
+		}
+		if previous, ok := latest[entry.EntryGuid]; ok && sameJSON(previous, data) {
+			continue
+		}
+
+		line, err := json.Marshal(EntryVersion{
+			SeenAt:    seenAt,
+			Year:      year.Name,
+			EntryGuid: entry.EntryGuid,
+			Entry:     data,
+		})
+		if err != nil {
+			return 0, err
+		}
+		buf.Write(line)
+		buf.WriteByte('\n')
+		recorded++
+	}
+
+	if recorded == 0 {
+		return 0, nil
+	}
+
+	if err := os.MkdirAll(filepath.Dir(filename), 0755); err != nil {
+		return 0, err
+	}
+	f, err := os.OpenFile(filename, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	if _, err := f.Write(buf.Bytes()); err != nil {
+		return 0, err
+	}
+	return recorded, nil
+}
+
+// loadLatestVersions reads a history file and returns the last recorded version of each entry
+func loadLatestVersions(filename string) (map[string]json.RawMessage, error) {
+	latest := make(map[string]json.RawMessage)
+
+	err := scanEntryHistory(filename, func(version EntryVersion) {
+		latest[version.EntryGuid] = version.Entry
+	})
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	return latest, nil
+}
+
+// latestEntryChange returns when an entry of the year last changed, from the versions recorded in
+// its history file. The recorded times are used rather than the file's modification time, which
+// doesn't survive copying or restoring the backup.
+func latestEntryChange(outDir string, year FiscalYear) (time.Time, bool) {
+	var latest time.Time
+	err := scanEntryHistory(entryHistoryFilename(outDir, year), func(version EntryVersion) {
+		if seenAt, err := time.Parse(time.RFC3339, version.SeenAt); err == nil && seenAt.After(latest) {
+			latest = seenAt
+		}
+	})
+	if err != nil || latest.IsZero() {
+		return time.Time{}, false
+	}
+	return latest, true
+}
+
+// scanEntryHistory calls fn for every version in a history file, oldest first
+func scanEntryHistory(filename string, fn func(EntryVersion)) error {
+	f, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		var version EntryVersion
+		if err := json.Unmarshal(line, &version); err != nil {
+			return fmt.Errorf("invalid history line in %s: %w", filename, err)
+		}
+		fn(version)
+	}
+	return scanner.Err()
+}
+
+// LoadEntryHistory returns every recorded version of an entry across all accounting years, oldest first
+func LoadEntryHistory(outDir string, entryGuid string) ([]EntryVersion, error) {
+	matches, err := filepath.Glob(filepath.Join(outDir, "entries", "history", "history_*.jsonl"))
+	if err != nil {
+		return nil, err
+	}
+
+	var versions []EntryVersion
+	for _, match := range matches {
+		err := scanEntryHistory(match, func(version EntryVersion) {
+			if version.EntryGuid == entryGuid {
+				versions = append(versions, version)
+			}
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	sort.SliceStable(versions, func(i, j int) bool {
+		return versions[i].SeenAt < versions[j].SeenAt
+	})
+
+	return versions, nil
+}
+
+// FirstSeen returns when each entry of an accounting year was first recorded in its history file, by EntryGuid.
+// Dinero doesn't expose when an entry was registered, so this is the earliest time the backup saw it.
+func FirstSeen(outDir string, year FiscalYear) (map[string]string, error) {
+	firstSeen := make(map[string]string)
+	err := scanEntryHistory(entryHistoryFilename(outDir, year), func(version EntryVersion) {
+		if seenAt, ok := firstSeen[version.EntryGuid]; !ok || version.SeenAt < seenAt {
+			firstSeen[version.EntryGuid] = version.SeenAt
+		}
+	})
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	return firstSeen, nil
+}
+
+// ChangedFields returns the names of the fields that differ between two versions of an entry
+func ChangedFields(previous, current json.RawMessage) []string {
+	var prev, cur map[string]any
+	json.Unmarshal(previous, &prev)
+	json.Unmarshal(current, &cur)
+
+	var fields []string
+	for name, value := range cur {
+		if !reflect.DeepEqual(prev[name], value) {
+			fields = append(fields, name)
+		}
+	}
+	for name := range prev {
+		if _, ok := cur[name]; !ok {
+			fields = append(fields, name)
+		}
+	}
+
+	sort.Strings(fields)
+	return fields
+}
+
+// sameJSON reports whether two JSON documents are semantically equal, ignoring key order and whitespace
+func sameJSON(a, b []byte) bool {
+	var va, vb any
+	if err := json.Unmarshal(a, &va); err != nil {
+		return false
+	}
+	if err := json.Unmarshal(b, &vb); err != nil {
+		return false
+	}
+	return reflect.DeepEqual(va, vb)
+}
@@ -0,0 +1,118 @@
+package backup
+
+import (
+	"fmt"
+	"log"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/rostved/dinero-backup/state"
+)
+
+// AllFields selects every field known for a resource instead of the default list
+const AllFields = "all"
+
+// defaultFields are the fields requested when no field selection is configured
+var defaultFields = map[string]string{
+	"invoices": "Guid,ContactName,Date,Description,TotalInclVat,Status,CreatedAt,UpdatedAt,DeletedAt,Number,ExternalReference,ContactGuid,PaymentDate,TotalExclVat,Currency",
+	"contacts": "" +
+		"Name,ContactGuid,ExternalReference,IsPerson,Street,ZipCode,City,CountryKey,Phone," +
+		"Email,Webpage,AttPerson,VatNumber,EanNumber,PaymentConditionType,PaymentConditionNumberOfDays," +
+		"IsMember,MemberNumber,CompanyStatus,VatRegionKey,CreatedAt,UpdatedAt,DeletedAt,PreferredInvoiceLanguageKey," +
+		"PreferredInvoiceCurrencyKey",
+}
+
+var fieldNamePattern = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9]*$`)
+
+// ResolveFields turns a configured field selection for a resource into the list of fields to request.
+// An empty selection gives the default list and "all" gives every field in the resource's schema;
+// other names must be fields known to the schema. Fields the backup depends on (the schema's
+// required fields) are always included.
+func ResolveFields(resource, selection string) ([]string, error) {
+	schema, err := loadSchema(resource)
+	if err != nil {
+		return nil, err
+	}
+
+	var fields []string
+	switch strings.TrimSpace(strings.ToLower(selection)) {
+	case "":
+		fields = strings.Split(defaultFields[resource], ",")
+	case AllFields:
+		fields = strings.Split(defaultFields[resource], ",")
+		var extra []string
+		for name := range schema.Properties {
+			extra = append(extra, name)
+		}
+		sort.Strings(extra)
+		fields = append(fields, extra...)
+	default:
+		for _, name := range strings.Split(selection, ",") {
+			name = strings.TrimSpace(name)
+			if name == "" {
+				continue
+			}
+			if !fieldNamePattern.MatchString(name) {
+				return nil, fmt.Errorf("invalid %s field name %q", resource, name)
+			}
+			if _, known := schema.Properties[name]; !known {
+				return nil, fmt.Errorf("unknown %s field %q (use %q for every known field)", resource, name, AllFields)
+			}
+			fields = append(fields, name)
+		}
+	}
+
+	fields = append(fields, schema.Required...)
+	return uniqueFields(fields), nil
+}
+
+// checkSelectedFields warns about every requested field the API doesn't return in a response.
+// It is called with the first response of a run; an empty response can't be checked.
+func checkSelectedFields(resource string, data []byte, fields []string) {
+	items, err := responseItems(data)
+	if err != nil || len(items) == 0 {
+		return
+	}
+
+	for _, field := range fields {
+		returned := false
+		for _, item := range items {
+			if _, ok := item[field]; ok {
+				returned = true
+				break
+			}
+		}
+		if !returned {
+			log.Printf("Warning: %s field %s was requested but isn't returned by the API.", resource, field)
+		}
+	}
+}
+
+// fieldSelectionChanged reports whether fields differ from the selection of the last backup of a resource.
+// Records already backed up only hold the fields selected back then, so a changed selection means
+// every record is fetched again. Backups from before the selection was recorded count as unchanged.
+func fieldSelectionChanged(stateManager *state.Manager, resource string, fields []string) bool {
+	previous := stateManager.GetSelectedFields(resource)
+	return previous != "" && previous != fieldSelectionKey(fields)
+}
+
+// fieldSelectionKey is the order-independent form of a field selection stored in the state
+func fieldSelectionKey(fields []string) string {
+	sorted := append([]string(nil), fields...)
+	sort.Strings(sorted)
+	return strings.Join(sorted, ",")
+}
+
+// uniqueFields removes duplicates while keeping the first occurrence of each field
+func uniqueFields(fields []string) []string {
+	seen := make(map[string]bool)
+	result := make([]string, 0, len(fields))
+	for _, name := range fields {
+		if !seen[name] {
+			seen[name] = true
+			result = append(result, name)
+		}
+	}
+	return result
+}
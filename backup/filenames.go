@@ -0,0 +1,63 @@
+package backup
+
+import (
+	"path/filepath"
+	"strings"
+	"unicode"
+)
+
+// maxFileNameLength keeps sanitized names well below common filesystem limits
+const maxFileNameLength = 100
+
+// sanitizeFileName turns an arbitrary name from the API into a safe single path component.
+// Path separators, control characters and characters reserved on Windows are replaced,
+// so a name can never escape its directory or fail to be created.
+func sanitizeFileName(name string) string {
+	var b strings.Builder
+	for _, r := range name {
+		switch {
+		case r == '/' || r == '\\':
+			b.WriteRune('_')
+		case strings.ContainsRune(`<>:"|?*`, r):
+			b.WriteRune('_')
+		case unicode.IsControl(r):
+			continue
+		default:
+			b.WriteRune(r)
+		}
+	}
+
+	// Leading/trailing dots and spaces are invalid on Windows and ".." would refer to the parent directory
+	sanitized := strings.Trim(b.String(), ". ")
+
+	if len(sanitized) > maxFileNameLength {
+		ext := filepath.Ext(sanitized)
+		if len(ext) > 10 {
+			ext = ""
+		}
+		base := strings.TrimSuffix(sanitized, ext)
+		sanitized = strings.ToValidUTF8(base[:maxFileNameLength-len(ext)], "") + ext
+	}
+
+	if sanitized == "" {
+		return "file"
+	}
+	return sanitized
+}
+
+// storedFileName builds a collision-safe name for a downloaded file by appending
+// the (short or full) GUID to the sanitized original name, e.g. "scan_1a2b3c4d.pdf"
+func storedFileName(originalName, guid string, fullGuid bool) string {
+	name := sanitizeFileName(originalName)
+	if originalName == "" {
+		name = "file.pdf"
+	}
+
+	suffix := sanitizeFileName(guid)
+	if !fullGuid && len(suffix) > 8 {
+		suffix = suffix[:8]
+	}
+
+	ext := filepath.Ext(name)
+	return strings.TrimSuffix(name, ext) + "_" + suffix + ext
+}
@@ -3,18 +3,18 @@ package backup
 import (
 	"encoding/json"
 	"fmt"
-	"io"
 	"log"
 	"net/url"
 	"os"
 	"path/filepath"
+	"strings"
 	"time"
 
 	"github.com/rostved/dinero-backup/dinero"
 	"github.com/rostved/dinero-backup/state"
 )
 
-func BackupInvoices(client *dinero.Client, stateManager *state.Manager, outDir string, dryRun bool) error {
+func BackupInvoices(client *dinero.Client, stateManager *state.Manager, outDir string, dryRun bool, fields []string, selection *Selection, csvDialect *CSVDialect) error {
 	log.Println("Backing up Invoices...")
 
 	if !dryRun {
@@ -27,16 +27,34 @@ func BackupInvoices(client *dinero.Client, stateManager *state.Manager, outDir s
 	}
 
 	lastSync := stateManager.GetLastSyncInvoices()
+	if selection == nil && fieldSelectionChanged(stateManager, "invoices", fields) {
+		log.Println("INVOICE_FIELDS changed since the last run, fetching all invoices again.")
+		lastSync = state.DefaultState.LastSync.Invoices
+	}
 	now := time.Now().UTC().Format(time.RFC3339)
 	hasData := false
 
-	fields := "Guid,ContactName,Date,Description,TotalInclVat,Status,CreatedAt,UpdatedAt,DeletedAt,Number,ExternalReference,ContactGuid,PaymentDate,TotalExclVat,Currency"
 	params := url.Values{}
-	params.Set("fields", fields)
-	params.Set("changesSince", lastSync)
+	params.Set("fields", strings.Join(fields, ","))
+	if selection != nil {
+		// Re-pull everything dated within the selection instead of changes since the last sync
+		log.Printf("Fetching invoices dated %s (selective)", selection)
+		params.Set("startDate", selection.From.Format("2006-01-02"))
+		params.Set("endDate", selection.To.Format("2006-01-02"))
+	} else {
+		params.Set("changesSince", lastSync)
+	}
 
 	// Fetch Active Invoices
-	data, err := client.Get("/v1/{organizationId}/invoices", params)
+	data, err := fetchDocumentPages(client, "/v1/{organizationId}/invoices", params, func(page int, data []byte) error {
+		if err := validateResponse("invoices", data, fields); err != nil {
+			return err
+		}
+		if page == 0 {
+			checkSelectedFields("invoices", data, fields)
+		}
+		return nil
+	})
 	if err != nil {
 		return err
 	}
@@ -59,11 +77,20 @@ func BackupInvoices(client *dinero.Client, stateManager *state.Manager, outDir s
 		}
 
 		// Download PDFs for booked invoices (all non-Draft invoices have been booked)
+		downloaded, skipped := 0, 0
 		for _, invoice := range response.Collection {
 			if invoice.Status != "Draft" {
 				pdfFilename := filepath.Join(outDir, "invoices", fmt.Sprintf("%d.pdf", invoice.Number))
+
+				// Skip if the invoice hasn't changed since its PDF was downloaded
+				if invoicePDFUpToDate(pdfFilename, invoice) {
+					skipped++
+					continue
+				}
+
 				if !dryRun {
-					stream, err := client.GetPDF(fmt.Sprintf("/v1/{organizationId}/invoices/%s", invoice.Guid))
+					endpoint := fmt.Sprintf("/v1/{organizationId}/invoices/%s", invoice.Guid)
+					download, err := client.GetPDF(endpoint)
 					if err != nil {
 						if client.Debug {
 							log.Printf("Failed to download PDF for invoice %d: %v", invoice.Number, err)
@@ -71,32 +98,30 @@ func BackupInvoices(client *dinero.Client, stateManager *state.Manager, outDir s
 						continue
 					}
 
-					outFile, err := os.Create(pdfFilename)
+					_, changed, err := downloadVersionedDocument(download, endpoint, invoice.Guid, "", invoice.UpdatedAt, pdfFilename)
 					if err != nil {
-						stream.Close()
-						log.Printf("Failed to create PDF file %s: %v", pdfFilename, err)
+						log.Printf("Failed to write PDF %s: %v", pdfFilename, err)
 						continue
 					}
-
-					_, err = io.Copy(outFile, stream)
-					outFile.Close()
-					stream.Close()
-
-					if err != nil {
-						log.Printf("Failed to write PDF %s: %v", pdfFilename, err)
-					} else if client.Debug {
-						log.Printf("Downloaded invoice PDF: %d", invoice.Number)
+					if changed {
+						downloaded++
+						if client.Debug {
+							log.Printf("Downloaded invoice PDF: %d", invoice.Number)
+						}
+					} else {
+						skipped++
 					}
 				} else {
 					log.Printf("[Dry Run] Would download PDF for invoice %d", invoice.Number)
 				}
 			}
 		}
+		log.Printf("Downloaded %d invoice PDFs (%d unchanged).", downloaded, skipped)
 	}
 
 	// Fetch Deleted Invoices
 	params.Set("deletedOnly", "true")
-	deletedData, err := client.Get("/v1/{organizationId}/invoices", params)
+	deletedData, err := fetchDocumentPages(client, "/v1/{organizationId}/invoices", params, nil)
 	if err == nil {
 		var deletedResponse PaginatedResponse
 		if err := json.Unmarshal(deletedData, &deletedResponse); err == nil && len(deletedResponse.Collection) > 0 {
@@ -113,15 +138,101 @@ func BackupInvoices(client *dinero.Client, stateManager *state.Manager, outDir s
 		}
 	}
 
+	// The CSV holds the latest version of every invoice, so it's rewritten even without changes
+	if csvDialect != nil {
+		if err := saveSnapshotCSV(outDir, "invoices", csvDialect, dryRun); err != nil {
+			return err
+		}
+	}
+
+	// A selective run doesn't move the incremental cursor
+	if selection != nil {
+		return nil
+	}
+
 	// Only update lastSync if we got data back (endpoint might be unstable)
-	if hasData && !dryRun {
-		stateManager.UpdateInvoices(now)
+	if !dryRun {
+		stateManager.UpdateSelectedFields("invoices", fieldSelectionKey(fields))
+		if hasData {
+			stateManager.UpdateInvoices(now)
+		}
 		if err := stateManager.Save(); err != nil {
 			return err
 		}
-	} else if dryRun && hasData {
+	} else if hasData {
 		log.Printf("[Dry Run] Would update state.invoices to %s", now)
 	}
 
 	return nil
 }
+
+// documentPageSize is the page size requested for invoices and credit notes
+const documentPageSize = 100
+
+// fetchDocumentPages fetches every page of an invoice or credit note list and combines the pages
+// into one response, so the run's snapshot holds every matching document. validate is called with
+// each page as returned by the API.
+func fetchDocumentPages(client *dinero.Client, endpoint string, params url.Values, validate func(page int, data []byte) error) ([]byte, error) {
+	collection := []json.RawMessage{}
+	var pagination json.RawMessage
+
+	for page := 0; ; page++ {
+		pageParams := url.Values{}
+		for key, values := range params {
+			pageParams[key] = values
+		}
+		pageParams.Set("page", fmt.Sprintf("%d", page))
+		pageParams.Set("pageSize", fmt.Sprintf("%d", documentPageSize))
+
+		data, err := client.Get(endpoint, pageParams)
+		if err != nil {
+			return nil, err
+		}
+		if validate != nil {
+			if err := validate(page, data); err != nil {
+				return nil, err
+			}
+		}
+
+		var response struct {
+			Collection []json.RawMessage `json:"Collection"`
+			Pagination json.RawMessage   `json:"Pagination"`
+		}
+		if err := json.Unmarshal(data, &response); err != nil {
+			return nil, err
+		}
+		if page == 0 {
+			pagination = response.Pagination
+		}
+		collection = append(collection, response.Collection...)
+
+		// Stop at a short page, or at the total reported by the API
+		var total struct {
+			Result int `json:"Result"`
+		}
+		json.Unmarshal(response.Pagination, &total)
+		if len(response.Collection) < documentPageSize || (total.Result > 0 && len(collection) >= total.Result) {
+			break
+		}
+	}
+
+	return json.Marshal(struct {
+		Collection []json.RawMessage `json:"Collection"`
+		Pagination json.RawMessage   `json:"Pagination,omitempty"`
+	}{collection, pagination})
+}
+
+// invoicePDFUpToDate reports whether the PDF on disk was downloaded for the invoice's current UpdatedAt
+func invoicePDFUpToDate(pdfFilename string, invoice Invoice) bool {
+	if invoice.UpdatedAt == "" {
+		return false
+	}
+	if _, err := os.Stat(pdfFilename); err != nil {
+		return false
+	}
+	meta, err := loadDocumentMeta(pdfFilename)
+	if err != nil {
+		return false
+	}
+	return meta.SourceUpdatedAt == invoice.UpdatedAt
+}
@@ -0,0 +1,80 @@
+package backup
+
+import (
+	"bytes"
+	"fmt"
+	"math/big"
+	"strconv"
+)
+
+// Money is an amount in øre (hundredths of a krone) stored as a fixed-point integer,
+// so amounts and running balances are exact instead of accumulating float rounding errors
+type Money int64
+
+// ParseMoney parses a decimal amount exactly, rounding to whole øre (half away from zero)
+func ParseMoney(s string) (Money, error) {
+	r, ok := new(big.Rat).SetString(s)
+	if !ok {
+		return 0, fmt.Errorf("invalid amount %q", s)
+	}
+	r.Mul(r, big.NewRat(100, 1))
+
+	quo, rem := new(big.Int).QuoRem(r.Num(), r.Denom(), new(big.Int))
+
+	// Round half away from zero: compare 2*|rem| with the denominator
+	rem.Abs(rem).Lsh(rem, 1)
+	if rem.Cmp(r.Denom()) >= 0 {
+		if r.Sign() < 0 {
+			quo.Sub(quo, big.NewInt(1))
+		} else {
+			quo.Add(quo, big.NewInt(1))
+		}
+	}
+
+	if !quo.IsInt64() {
+		return 0, fmt.Errorf("amount %q out of range", s)
+	}
+	return Money(quo.Int64()), nil
+}
+
+// UnmarshalJSON decodes a JSON number (or numeric string) without going through float64
+func (m *Money) UnmarshalJSON(data []byte) error {
+	data = bytes.TrimSpace(data)
+	if bytes.Equal(data, []byte("null")) {
+		*m = 0
+		return nil
+	}
+	if len(data) > 0 && data[0] == '"' {
+		unquoted, err := strconv.Unquote(string(data))
+		if err != nil {
+			return err
+		}
+		data = []byte(unquoted)
+	}
+
+	parsed, err := ParseMoney(string(data))
+	if err != nil {
+		return err
+	}
+	*m = parsed
+	return nil
+}
+
+// MarshalJSON encodes the amount as a JSON number with two decimals
+func (m Money) MarshalJSON() ([]byte, error) {
+	return []byte(m.String()), nil
+}
+
+// String formats the amount with a dot as decimal separator, e.g. "-1234.50"
+func (m Money) String() string {
+	sign := ""
+	v := int64(m)
+	if v < 0 {
+		sign = "-"
+	}
+	u := uint64(v)
+	if v < 0 {
+		u = uint64(-(v + 1)) + 1
+	}
+	return fmt.Sprintf("%s%d.%02d", sign, u/100, u%100)
+}
@@ -0,0 +1,98 @@
+package backup
+
+import (
+	"encoding/json"
+	"math"
+	"testing"
+)
+
+func TestParseMoney(t *testing.T) {
+	tests := []struct {
+		input string
+		want  Money
+	}{
+		{"0", 0},
+		{"1234.5", 123450},
+		{"0.005", 1},
+		{"0.0049", 0},
+		{"1.005", 101},
+		{"2.675", 268},
+		{"-0.005", -1},
+		{"-0.0049", 0},
+		{"-1.005", -101},
+		{"-1234.567", -123457},
+		{"1e2", 10000},
+	}
+
+	for _, tt := range tests {
+		got, err := ParseMoney(tt.input)
+		if err != nil {
+			t.Errorf("ParseMoney(%q) returned error: %v", tt.input, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("ParseMoney(%q) = %d, want %d", tt.input, got, tt.want)
+		}
+	}
+}
+
+func TestParseMoneyInvalid(t *testing.T) {
+	for _, input := range []string{"", "abc", "1,50", "1e20"} {
+		if got, err := ParseMoney(input); err == nil {
+			t.Errorf("ParseMoney(%q) = %d, want error", input, got)
+		}
+	}
+}
+
+func TestMoneyUnmarshalJSON(t *testing.T) {
+	tests := []struct {
+		input string
+		want  Money
+	}{
+		{`12.345`, 1235},
+		{`-12.345`, -1235},
+		{`"12.345"`, 1235},
+		{`"-0.5"`, -50},
+		{`null`, 0},
+		{` 7 `, 700},
+	}
+
+	for _, tt := range tests {
+		var got Money
+		if err := json.Unmarshal([]byte(tt.input), &got); err != nil {
+			t.Errorf("Unmarshal(%s) returned error: %v", tt.input, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("Unmarshal(%s) = %d, want %d", tt.input, got, tt.want)
+		}
+	}
+
+	var m Money
+	if err := json.Unmarshal([]byte(`"abc"`), &m); err == nil {
+		t.Errorf("Unmarshal(\"abc\") = %d, want error", m)
+	}
+}
+
+func TestMoneyString(t *testing.T) {
+	tests := []struct {
+		input Money
+		want  string
+	}{
+		{0, "0.00"},
+		{5, "0.05"},
+		{123450, "1234.50"},
+		{-1, "-0.01"},
+		{-99, "-0.99"},
+		{-100, "-1.00"},
+		{-123450, "-1234.50"},
+		{math.MaxInt64, "92233720368547758.07"},
+		{math.MinInt64, "-92233720368547758.08"},
+	}
+
+	for _, tt := range tests {
+		if got := tt.input.String(); got != tt.want {
+			t.Errorf("Money(%d).String() = %q, want %q", int64(tt.input), got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,146 @@
+package backup
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/url"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/rostved/dinero-backup/dinero"
+	"github.com/rostved/dinero-backup/state"
+)
+
+// organizationResource describes an organization-level endpoint to snapshot
+type organizationResource struct {
+	Name     string
+	Endpoint string
+	Params   url.Values
+	// Required resources fail the backup; optional ones may be unavailable depending on the Dinero plan,
+	// so their failures are logged and recorded in organization/status.json instead
+	Required bool
+}
+
+// OrganizationStatus lists the optional resources that couldn't be fetched in the last run,
+// saved as organization/status.json
+type OrganizationStatus struct {
+	GeneratedAt string                `json:"GeneratedAt"`
+	Failed      []OrganizationFailure `json:"Failed"`
+}
+
+// OrganizationFailure is an optional organization resource that couldn't be fetched
+type OrganizationFailure struct {
+	Resource string `json:"Resource"`
+	Error    string `json:"Error"`
+}
+
+var organizationResources = []organizationResource{
+	{
+		Name:     "organization",
+		Endpoint: "/v1/organizations",
+		Params: url.Values{"fields": {"Id,Name,IsPro,IsPayingPro,IsVatFree,Email,IsTaxFreeUnion," +
+			"VatNumber,Street,ZipCode,City,CountryKey,Phone,Website,IsVatRegistered"}},
+		Required: true,
+	},
+	{Name: "company", Endpoint: "/v1/{organizationId}/settings/company"},
+	{Name: "vat", Endpoint: "/v1/{organizationId}/settings/vat"},
+	{Name: "invoice_settings", Endpoint: "/v1/{organizationId}/settings/invoice"},
+	{Name: "invoice_templates", Endpoint: "/v1/{organizationId}/invoices/templates"},
+	{Name: "accountingyears", Endpoint: "/v1/{organizationId}/accountingyears", Required: true},
+	{Name: "accounts_entry", Endpoint: "/v1/{organizationId}/accounts/entry"},
+	{Name: "accounts_deposit", Endpoint: "/v1/{organizationId}/accounts/deposit"},
+}
+
+// BackupOrganization snapshots company profile, settings, templates, accounting years and
+// chart of accounts into organization/. Files are only rewritten when their content changes.
+func BackupOrganization(client *dinero.Client, stateManager *state.Manager, outDir string, dryRun bool) error {
+	log.Println("Backing up Organization...")
+
+	dir := filepath.Join(outDir, "organization")
+	if !dryRun {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return err
+		}
+	}
+
+	now := time.Now().UTC().Format(time.RFC3339)
+	changed := 0
+	failed := []OrganizationFailure{}
+
+	for _, resource := range organizationResources {
+		data, err := client.Get(resource.Endpoint, resource.Params)
+		if err != nil {
+			if resource.Required {
+				return fmt.Errorf("failed to fetch %s: %w", resource.Name, err)
+			}
+			log.Printf("Warning: skipping %s (not available): %v", resource.Name, err)
+			failed = append(failed, OrganizationFailure{Resource: resource.Name, Error: err.Error()})
+			continue
+		}
+
+		if resource.Name == "organization" {
+			data, err = selectOrganization(data, client.OrgID)
+			if err != nil {
+				return err
+			}
+		}
+
+		updated, err := saveSnapshot(dir, resource.Name, data, dryRun)
+		if err != nil {
+			return fmt.Errorf("failed to save %s: %w", resource.Name, err)
+		}
+		if updated {
+			changed++
+			log.Printf("Organization %s changed.", resource.Name)
+		} else if client.Debug {
+			log.Printf("Organization %s unchanged.", resource.Name)
+		}
+	}
+
+	log.Printf("Organization snapshot complete (%d changed, %d not available).", changed, len(failed))
+
+	if dryRun {
+		return nil
+	}
+
+	status, err := json.MarshalIndent(OrganizationStatus{GeneratedAt: now, Failed: failed}, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(filepath.Join(dir, "status.json"), status, 0644); err != nil {
+		return err
+	}
+
+	// The last sync time marks the last snapshot in which every resource was fetched
+	if len(failed) > 0 {
+		log.Printf("Last complete organization snapshot: %s", stateManager.GetLastSyncOrganization())
+		return nil
+	}
+	stateManager.UpdateOrganization(now)
+	return stateManager.Save()
+}
+
+// selectOrganization picks the configured organization from the /organizations list,
+// so the snapshot only describes the company this backup belongs to
+func selectOrganization(data []byte, orgID string) ([]byte, error) {
+	var organizations []json.RawMessage
+	if err := json.Unmarshal(data, &organizations); err != nil {
+		return nil, fmt.Errorf("failed to parse organizations: %w", err)
+	}
+
+	for _, raw := range organizations {
+		var obj struct {
+			Id json.Number `json:"Id"`
+		}
+		if err := json.Unmarshal(raw, &obj); err != nil {
+			continue
+		}
+		if obj.Id.String() == orgID {
+			return raw, nil
+		}
+	}
+
+	return nil, fmt.Errorf("organization %s not found in organizations list", orgID)
+}
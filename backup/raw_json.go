@@ -0,0 +1,70 @@
+package backup
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+)
+
+// setRawField sets a top-level field in a JSON object, keeping the order of the existing fields
+// so the stored object only changes where it has to. The field is appended if it doesn't exist.
+func setRawField(raw json.RawMessage, key string, value json.RawMessage) (json.RawMessage, error) {
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	dec.UseNumber()
+
+	tok, err := dec.Token()
+	if err != nil {
+		return nil, err
+	}
+	if delim, ok := tok.(json.Delim); !ok || delim != '{' {
+		return nil, fmt.Errorf("expected JSON object")
+	}
+
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	found := false
+	first := true
+
+	for dec.More() {
+		tok, err := dec.Token()
+		if err != nil {
+			return nil, err
+		}
+		name, ok := tok.(string)
+		if !ok {
+			return nil, fmt.Errorf("expected object key")
+		}
+
+		var fieldValue json.RawMessage
+		if err := dec.Decode(&fieldValue); err != nil {
+			return nil, err
+		}
+		if name == key {
+			fieldValue = value
+			found = true
+		}
+
+		if !first {
+			buf.WriteByte(',')
+		}
+		first = false
+		writeRawField(&buf, name, fieldValue)
+	}
+
+	if !found {
+		if !first {
+			buf.WriteByte(',')
+		}
+		writeRawField(&buf, key, value)
+	}
+	buf.WriteByte('}')
+
+	return buf.Bytes(), nil
+}
+
+func writeRawField(buf *bytes.Buffer, name string, value json.RawMessage) {
+	keyData, _ := json.Marshal(name)
+	buf.Write(keyData)
+	buf.WriteByte(':')
+	buf.Write(value)
+}
@@ -0,0 +1,368 @@
+package backup
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"time"
+
+	"github.com/rostved/dinero-backup/dinero"
+	"github.com/rostved/dinero-backup/state"
+)
+
+// entriesReconcileInterval is how often each accounting year is checked for deleted entries
+const entriesReconcileInterval = 7 * 24 * time.Hour
+
+// ReconcileOptions controls a full reconciliation of entries (run --reconcile)
+type ReconcileOptions struct {
+	Enabled bool
+	// Replace brings the local file in line with Dinero instead of only reporting drift
+	Replace bool
+}
+
+// DriftReport describes how local entries differ from a fresh fetch of an accounting year
+type DriftReport struct {
+	Year        string         `json:"Year"`
+	GeneratedAt string         `json:"GeneratedAt"`
+	Replaced    bool           `json:"Replaced"`
+	Added       []RawEntry     `json:"Added"`
+	Removed     []RawEntry     `json:"Removed"`
+	Changed     []ChangedEntry `json:"Changed"`
+}
+
+// ChangedEntry lists the fields of an entry that differ between local and upstream
+type ChangedEntry struct {
+	EntryGuid string `json:"EntryGuid"`
+	// Key identifies entries matched without a GUID, e.g. "primo:1000" for the primo entry of account 1000
+	Key    string                `json:"Key,omitempty"`
+	Fields map[string]FieldDrift `json:"Fields"`
+}
+
+// FieldDrift holds the local and upstream value of a changed field
+type FieldDrift struct {
+	Local    any `json:"Local"`
+	Upstream any `json:"Upstream"`
+}
+
+// reconcileYearWithReport fetches an accounting year fresh via /entries, diffs it against the
+// merged local file and writes a drift report to entries/drift/. With replace, the local file is
+// brought in line with Dinero (entries removed upstream are tombstoned, not dropped).
+func reconcileYearWithReport(client *dinero.Client, stateManager *state.Manager, outDir string, year FiscalYear, dryRun bool, csvDialect *CSVDialect, replace bool) error {
+	existing, err := loadExistingEntries(outDir, year)
+	if err != nil {
+		return fmt.Errorf("failed to load local entries: %w", err)
+	}
+
+	log.Printf("Reconciling entries for year %s (full)...", year.Name)
+
+	upstream, err := fetchYearEntries(client, year)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now().UTC()
+	report := diffEntries(existing, upstream)
+	report.Year = year.Name
+	report.GeneratedAt = now.Format(time.RFC3339)
+	report.Replaced = replace
+
+	log.Printf("Year %s drift: %d added, %d removed, %d changed.", year.Name, len(report.Added), len(report.Removed), len(report.Changed))
+
+	reportData, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal drift report: %w", err)
+	}
+	reportFilename := filepath.Join(outDir, "entries", "drift", fmt.Sprintf("drift_%s_%s.json", year.FileName(), now.Format("20060102150405")))
+
+	if dryRun {
+		log.Printf("[Dry Run] Would save drift report to %s", reportFilename)
+	} else {
+		if err := os.MkdirAll(filepath.Dir(reportFilename), 0755); err != nil {
+			return err
+		}
+		if err := os.WriteFile(reportFilename, reportData, 0644); err != nil {
+			return err
+		}
+		log.Printf("Saved drift report to %s", reportFilename)
+	}
+
+	if !replace {
+		return nil
+	}
+
+	reconciled, _, _ := applyTombstones(existing, upstream, now.Format(time.RFC3339))
+	if err := saveEntriesFile(outDir, year, reconciled, csvDialect, dryRun); err != nil {
+		return err
+	}
+
+	if !dryRun {
+		stateManager.UpdateEntriesReconciled(year.Name, now.Format(time.RFC3339))
+		if err := stateManager.Save(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// diffEntries compares local entries to upstream entries, matched by entryMatchKey.
+// Entries already tombstoned locally count as missing. When a key matches several entries
+// (e.g. an account's primo entries), the entries that differ are reported as removed and added.
+func diffEntries(local, upstream []RawEntry) *DriftReport {
+	report := &DriftReport{
+		Added:   []RawEntry{},
+		Removed: []RawEntry{},
+		Changed: []ChangedEntry{},
+	}
+
+	localGroups := make(map[string][]RawEntry)
+	var keys []string
+	for _, e := range local {
+		if e.TombstonedAt != "" {
+			continue
+		}
+		key := entryMatchKey(e)
+		if _, ok := localGroups[key]; !ok {
+			keys = append(keys, key)
+		}
+		localGroups[key] = append(localGroups[key], e)
+	}
+
+	upstreamGroups := make(map[string][]RawEntry)
+	for _, e := range upstream {
+		key := entryMatchKey(e)
+		if _, ok := upstreamGroups[key]; !ok {
+			if _, ok := localGroups[key]; !ok {
+				keys = append(keys, key)
+			}
+		}
+		upstreamGroups[key] = append(upstreamGroups[key], e)
+	}
+
+	for _, key := range keys {
+		l, u := localGroups[key], upstreamGroups[key]
+		if len(l) == 1 && len(u) == 1 {
+			if fields := diffEntryFields(l[0], u[0]); len(fields) > 0 {
+				changed := ChangedEntry{EntryGuid: u[0].EntryGuid, Fields: fields}
+				if u[0].EntryGuid == "" || u[0].Type == "Primo" {
+					changed.Key = key
+				}
+				report.Changed = append(report.Changed, changed)
+			}
+			continue
+		}
+
+		removed, added := diffEntryGroups(l, u)
+		report.Removed = append(report.Removed, removed...)
+		report.Added = append(report.Added, added...)
+	}
+
+	sort.Slice(report.Changed, func(i, j int) bool {
+		if report.Changed[i].EntryGuid != report.Changed[j].EntryGuid {
+			return report.Changed[i].EntryGuid < report.Changed[j].EntryGuid
+		}
+		return report.Changed[i].Key < report.Changed[j].Key
+	})
+
+	return report
+}
+
+// diffEntryGroups returns the local entries without an identical upstream entry and
+// the upstream entries without an identical local entry
+func diffEntryGroups(local, upstream []RawEntry) ([]RawEntry, []RawEntry) {
+	remaining := make(map[string]int)
+	for _, e := range upstream {
+		remaining[entryContent(e)]++
+	}
+
+	var removed []RawEntry
+	for _, e := range local {
+		content := entryContent(e)
+		if remaining[content] > 0 {
+			remaining[content]--
+			continue
+		}
+		removed = append(removed, e)
+	}
+
+	var added []RawEntry
+	for _, e := range upstream {
+		content := entryContent(e)
+		if remaining[content] > 0 {
+			remaining[content]--
+			added = append(added, e)
+		}
+	}
+
+	return removed, added
+}
+
+// diffEntryFields returns the fields that differ between two versions of an entry,
+// including fields not modelled by Entry
+func diffEntryFields(local, upstream RawEntry) map[string]FieldDrift {
+	toMap := func(e RawEntry) map[string]any {
+		var m map[string]any
+		json.Unmarshal(e.Raw, &m)
+		delete(m, "TombstonedAt")
+		return m
+	}
+
+	localFields := toMap(local)
+	upstreamFields := toMap(upstream)
+
+	fields := make(map[string]FieldDrift)
+	for name, value := range upstreamFields {
+		if !reflect.DeepEqual(localFields[name], value) {
+			fields[name] = FieldDrift{Local: localFields[name], Upstream: value}
+		}
+	}
+	for name, value := range localFields {
+		if _, ok := upstreamFields[name]; !ok {
+			fields[name] = FieldDrift{Local: value, Upstream: nil}
+		}
+	}
+	return fields
+}
+
+// isReconcileDue reports whether an accounting year hasn't been reconciled within the interval
+func isReconcileDue(stateManager *state.Manager, year FiscalYear) bool {
+	last, err := time.Parse(time.RFC3339, stateManager.GetEntriesReconciled(year.Name))
+	if err != nil {
+		return true
+	}
+	return time.Since(last) >= entriesReconcileInterval
+}
+
+// reconcileDeletedEntries re-fetches an accounting year via /entries and tombstones
+// local entries that no longer exist upstream, e.g. deleted draft bookings or re-booked vouchers
+func reconcileDeletedEntries(client *dinero.Client, stateManager *state.Manager, outDir string, year FiscalYear, dryRun bool, csvDialect *CSVDialect) error {
+	existing, err := loadExistingEntries(outDir, year)
+	if err != nil {
+		// Nothing to reconcile; the next run will fetch the full year
+		return nil
+	}
+
+	log.Printf("Reconciling entries for year %s...", year.Name)
+
+	upstream, err := fetchYearEntries(client, year)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now().UTC().Format(time.RFC3339)
+	reconciled, tombstoned, restored := applyTombstones(existing, upstream, now)
+
+	if tombstoned > 0 || restored > 0 {
+		log.Printf("Year %s: %d entries tombstoned, %d restored.", year.Name, tombstoned, restored)
+	} else {
+		log.Printf("Year %s: no deleted entries found.", year.Name)
+	}
+
+	if err := saveEntriesFile(outDir, year, reconciled, csvDialect, dryRun); err != nil {
+		return err
+	}
+
+	if !dryRun {
+		stateManager.UpdateEntriesReconciled(year.Name, now)
+		if err := stateManager.Save(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// applyTombstones brings local entries in line with a full upstream fetch, matching entries by
+// entryMatchKey. Entries missing upstream are kept but marked with TombstonedAt; tombstoned entries
+// that reappear are restored. A matched group is replaced by its upstream entries, so an account's
+// primo entries are always replaced as a whole.
+// Returns the reconciled entries and the number of entries tombstoned and restored.
+func applyTombstones(existing, upstream []RawEntry, now string) ([]RawEntry, int, int) {
+	upstreamGroups := make(map[string][]RawEntry)
+	var upstreamKeys []string
+	for _, e := range upstream {
+		key := entryMatchKey(e)
+		if _, ok := upstreamGroups[key]; !ok {
+			upstreamKeys = append(upstreamKeys, key)
+		}
+		upstreamGroups[key] = append(upstreamGroups[key], e)
+	}
+
+	tombstoned, restored := 0, 0
+	emitted := make(map[string]bool)
+
+	// Update existing entries in place, preserving order
+	result := make([]RawEntry, 0, len(existing)+len(upstream))
+	for _, e := range existing {
+		key := entryMatchKey(e)
+		if current, ok := upstreamGroups[key]; ok {
+			if e.TombstonedAt != "" {
+				restored++
+			}
+			if !emitted[key] {
+				emitted[key] = true
+				result = append(result, current...)
+			}
+			continue
+		}
+
+		if e.TombstonedAt == "" {
+			if err := e.SetTombstoned(now); err != nil {
+				log.Printf("Could not tombstone entry %s: %v", e.EntryGuid, err)
+			} else {
+				tombstoned++
+			}
+		}
+		result = append(result, e)
+	}
+
+	// Append entries the incremental changes missed
+	for _, key := range upstreamKeys {
+		if !emitted[key] {
+			result = append(result, upstreamGroups[key]...)
+		}
+	}
+
+	return result, tombstoned, restored
+}
+
+// entryMatchKey identifies an entry across fetches: primo entries by account, as they may come
+// without a GUID, other entries by EntryGuid, and remaining entries without a GUID by their content
+func entryMatchKey(e RawEntry) string {
+	if e.Type == "Primo" {
+		return fmt.Sprintf("primo:%d", e.AccountNumber)
+	}
+	if e.EntryGuid != "" {
+		return "guid:" + e.EntryGuid
+	}
+	return "content:" + entryContent(e)
+}
+
+// entryContent returns the entry's raw object without the locally added TombstonedAt field
+func entryContent(e RawEntry) string {
+	var fields map[string]any
+	if err := json.Unmarshal(e.Raw, &fields); err != nil {
+		return string(e.Raw)
+	}
+	delete(fields, "TombstonedAt")
+	data, err := json.Marshal(fields)
+	if err != nil {
+		return string(e.Raw)
+	}
+	return string(data)
+}
+
+// activeEntries returns the entries that haven't been tombstoned
+func activeEntries(entries []Entry) []Entry {
+	result := make([]Entry, 0, len(entries))
+	for _, e := range entries {
+		if e.TombstonedAt == "" {
+			result = append(result, e)
+		}
+	}
+	return result
+}
@@ -0,0 +1,103 @@
+package backup
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/url"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/rostved/dinero-backup/dinero"
+)
+
+// Outcomes of fetching a report
+const (
+	reportSaved        = "saved"
+	reportUnchanged    = "unchanged"
+	reportNotAvailable = "not-available"
+	reportFailed       = "failed"
+)
+
+// reportRetries is how many times a report is requested before a transient error counts as a failure
+const reportRetries = 3
+
+// reportRetryDelay is the wait before the first retry; it doubles with every attempt
+var reportRetryDelay = 2 * time.Second
+
+// ReportOutcome is the result of fetching one report in a run
+type ReportOutcome struct {
+	Year     string `json:"Year"`
+	Report   string `json:"Report"`
+	Outcome  string `json:"Outcome"`
+	Attempts int    `json:"Attempts,omitempty"`
+	Error    string `json:"Error,omitempty"`
+	// Warning is set when a report variant looks like its parameters were ignored
+	Warning string `json:"Warning,omitempty"`
+}
+
+// ReportStatus lists the outcome of every report requested in the last run, saved as reports/status.json
+type ReportStatus struct {
+	GeneratedAt string          `json:"GeneratedAt"`
+	Outcomes    []ReportOutcome `json:"Outcomes"`
+}
+
+// fetchReport requests a report, retrying transient errors (rate limiting, server errors, timeouts).
+// Returns the number of attempts made.
+func fetchReport(client *dinero.Client, endpoint string, params url.Values) ([]byte, int, error) {
+	delay := reportRetryDelay
+	for attempt := 1; ; attempt++ {
+		data, err := client.Get(endpoint, params)
+		if err == nil || attempt == reportRetries || !dinero.IsTransient(err) {
+			return data, attempt, err
+		}
+		log.Printf("Transient error fetching %s (attempt %d of %d), retrying in %s: %v", endpoint, attempt, reportRetries, delay, err)
+		time.Sleep(delay)
+		delay *= 2
+	}
+}
+
+// summarizeReports logs the outcome counts and every missing report, saves reports/status.json
+// and returns an error if any report failed. Reports Dinero doesn't provide (404) are not an error.
+func summarizeReports(outDir string, outcomes []ReportOutcome, dryRun bool) error {
+	counts := make(map[string]int)
+	for _, outcome := range outcomes {
+		counts[outcome.Outcome]++
+		switch outcome.Outcome {
+		case reportNotAvailable:
+			log.Printf("Report %s for year %s is not available in Dinero.", outcome.Report, outcome.Year)
+		case reportFailed:
+			log.Printf("Report %s for year %s failed: %s", outcome.Report, outcome.Year, outcome.Error)
+		}
+		if outcome.Warning != "" {
+			log.Printf("Warning: report %s for year %s is %s.", outcome.Report, outcome.Year, outcome.Warning)
+		}
+	}
+
+	log.Printf("Reports: %d saved, %d unchanged, %d not available, %d failed.",
+		counts[reportSaved], counts[reportUnchanged], counts[reportNotAvailable], counts[reportFailed])
+
+	if len(outcomes) > 0 {
+		filename := filepath.Join(outDir, "reports", "status.json")
+		if dryRun {
+			log.Printf("[Dry Run] Would save report status to %s", filename)
+		} else {
+			data, err := json.MarshalIndent(ReportStatus{
+				GeneratedAt: time.Now().UTC().Format(time.RFC3339),
+				Outcomes:    outcomes,
+			}, "", "  ")
+			if err != nil {
+				return err
+			}
+			if err := os.WriteFile(filename, data, 0644); err != nil {
+				return err
+			}
+		}
+	}
+
+	if counts[reportFailed] > 0 {
+		return fmt.Errorf("%d report(s) failed, see %s", counts[reportFailed], filepath.Join(outDir, "reports", "status.json"))
+	}
+	return nil
+}
@@ -0,0 +1,116 @@
+package backup
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Report period granularities (run --report-periods)
+const (
+	reportPeriodsMonthly   = "monthly"
+	reportPeriodsQuarterly = "quarterly"
+)
+
+// periodicReportTypes are the reports that can be fetched per period within a year
+var periodicReportTypes = []string{"result", "balance"}
+
+// ReportOptions selects the report variants fetched in addition to the yearly reports
+type ReportOptions struct {
+	// Periods is "monthly" or "quarterly" to also fetch result and balance per period
+	Periods string
+	// PreviousYear adds comparison figures for the previous year
+	PreviousYear bool
+	// ZeroAccounts includes accounts without movements
+	ZeroAccounts bool
+	// SummaryAccounts includes summary (sum) accounts
+	SummaryAccounts bool
+}
+
+// Validate checks the report options given on the command line
+func (o ReportOptions) Validate() error {
+	switch o.Periods {
+	case "", reportPeriodsMonthly, reportPeriodsQuarterly:
+		return nil
+	default:
+		return fmt.Errorf("invalid report periods %q, expected %s or %s", o.Periods, reportPeriodsMonthly, reportPeriodsQuarterly)
+	}
+}
+
+// params returns the query parameters for the selected variants. The names aren't listed in the
+// public API reference, so fetched variants are checked against the default report (see checkVariant).
+func (o ReportOptions) params() url.Values {
+	params := url.Values{}
+	if o.PreviousYear {
+		params.Set("showPreviousYear", "true")
+	}
+	if o.ZeroAccounts {
+		params.Set("showZeroAccounts", "true")
+	}
+	if o.SummaryAccounts {
+		params.Set("includeSummaryAccounts", "true")
+	}
+	return params
+}
+
+// suffix names the variant in report file names, so each variant is versioned separately
+func (o ReportOptions) suffix() string {
+	var parts []string
+	if o.PreviousYear {
+		parts = append(parts, "prevyear")
+	}
+	if o.ZeroAccounts {
+		parts = append(parts, "zero")
+	}
+	if o.SummaryAccounts {
+		parts = append(parts, "summary")
+	}
+	if len(parts) == 0 {
+		return ""
+	}
+	return "_" + strings.Join(parts, "_")
+}
+
+// stateKey names the selected variants and periods in the state, so a closed year's variants are
+// fetched once when they are selected after the year's reports were fetched. Empty without options.
+func (o ReportOptions) stateKey() string {
+	key := o.suffix()
+	if o.Periods != "" {
+		key += "_" + o.Periods
+	}
+	return key
+}
+
+// reportPeriod is a month or quarter within an accounting year
+type reportPeriod struct {
+	Name string
+	From time.Time
+	To   time.Time
+}
+
+// reportPeriods splits an accounting year into months or quarters, leaving out periods that haven't started yet.
+// Months are named by calendar month (2024-07), quarters by their position in the accounting year (Q1).
+func reportPeriods(year FiscalYear, periods string, now time.Time) []reportPeriod {
+	months := 1
+	if periods == reportPeriodsQuarterly {
+		months = 3
+	} else if periods != reportPeriodsMonthly {
+		return nil
+	}
+
+	var result []reportPeriod
+	for from := year.From; !from.After(year.To) && !from.After(now); from = from.AddDate(0, months, 0) {
+		to := from.AddDate(0, months, -1)
+		if to.After(year.To) {
+			to = year.To
+		}
+
+		name := from.Format("2006-01")
+		if periods == reportPeriodsQuarterly {
+			name = fmt.Sprintf("Q%d", len(result)+1)
+		}
+		result = append(result, reportPeriod{Name: name, From: from, To: to})
+	}
+	return result
+}
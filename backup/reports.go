@@ -1,85 +1,244 @@
 package backup
 
 import (
+	"bytes"
 	"encoding/json"
 	"fmt"
 	"log"
+	"net/url"
 	"os"
 	"path/filepath"
-	"strconv"
+	"sort"
+	"strings"
 	"time"
 
 	"github.com/rostved/dinero-backup/dinero"
+	"github.com/rostved/dinero-backup/state"
 )
 
-func BackupReports(client *dinero.Client, outDir string, dryRun bool) error {
+// reportTypes are the reports fetched for every accounting year
+var reportTypes = []string{"balance", "result", "saldo"}
+
+func BackupReports(client *dinero.Client, stateManager *state.Manager, outDir string, dryRun bool, selection *Selection, options ReportOptions) error {
 	log.Println("Backing up Reports...")
 
+	reportsDir := filepath.Join(outDir, "reports")
 	if !dryRun {
-		if err := os.MkdirAll(filepath.Join(outDir, "reports"), 0755); err != nil {
+		if err := os.MkdirAll(reportsDir, 0755); err != nil {
 			return err
 		}
 	} else {
-        log.Printf("[Dry Run] Would ensure directory matches: %s", filepath.Join(outDir, "reports"))
+        log.Printf("[Dry Run] Would ensure directory matches: %s", reportsDir)
     }
 
     // Fetch accounting years
-	data, err := client.Get("/v1/{organizationId}/accountingyears", nil)
+	accountingYears, err := GetAccountingYears(client)
 	if err != nil {
 		return fmt.Errorf("failed to fetch accounting years: %w", err)
 	}
+	accountingYears = selectYears(accountingYears, selection)
 
-	var accountingYears []AccountingYear
-	if err := json.Unmarshal(data, &accountingYears); err != nil {
-		return err
-	}
+	now := time.Now().UTC()
+	var outcomes []ReportOutcome
+	for _, year := range accountingYears {
+		reports := yearReports(year, options, now)
+		variantKey := options.stateKey()
 
-	for _, str := range []string{"balance", "result", "saldo"} {
-		for _, accYear := range accountingYears {
-            endDate := accYear.ToDate
-            if endDate == "" {
-                endDate = accYear.DateEnd
-            }
-            
-            // Year logic
-            year := accYear.Name
-            
-            if year == "" && endDate != "" {
-                 t, err := time.Parse("2006-01-02", endDate)
-                 if err == nil {
-                     year = strconv.Itoa(t.Year())
-                 }
-            }
-            
-            if year == "" {
-                if client.Debug {
-                    log.Printf("Skipping accounting year with no name or end date: %+v", accYear)
-                }
-                continue
-            }
-
-			filename := filepath.Join(outDir, "reports", fmt.Sprintf("%s_%s.json", year, str))
-
-			if !dryRun {
-				reportData, err := client.Get(fmt.Sprintf("/v1/{organizationId}/%s/reports/%s", year, str), nil)
-				if err != nil {
-					// Handle 404 gracefully? Log it.
-                    if client.Debug {
-					    log.Printf("Error fetching %s for %s: %v", str, year, err)
-                    }
-					continue
+		// Closed years are only fetched again when their entries changed; a selection always refetches.
+		// Variants selected after a closed year was fetched are fetched for it once.
+		due := selection != nil || isReportYearDue(stateManager, outDir, year)
+		if !due {
+			if variantKey == "" || stateManager.GetReportsFetched(year.Name+variantKey) != "" {
+				if client.Debug {
+					log.Printf("Skipping reports for closed year %s (unchanged).", year.Name)
 				}
+				continue
+			}
+			reports = variantReports(reports)
+			log.Printf("Fetching report variants for closed year %s (not fetched for it yet).", year.Name)
+		}
 
-				if err := os.WriteFile(filename, reportData, 0644); err != nil {
-					return err
+		fetched := true
+		for _, report := range reports {
+			dir := filepath.Join(reportsDir, report.Dir)
+
+			if dryRun {
+				log.Printf("[Dry Run] Would save report: %s", filepath.Join(dir, report.Name+".json"))
+				continue
+			}
+
+			outcome := ReportOutcome{Year: year.Name, Report: report.Name}
+			endpoint := fmt.Sprintf("/v1/{organizationId}/%s/reports/%s", url.PathEscape(year.Name), report.Type)
+			reportData, attempts, err := fetchReport(client, endpoint, report.Params)
+			outcome.Attempts = attempts
+			if err != nil {
+				// A 404 means Dinero doesn't provide the report for the year, e.g. a year without postings
+				if dinero.IsNotFound(err) {
+					outcome.Outcome = reportNotAvailable
+				} else {
+					outcome.Outcome = reportFailed
+					outcome.Error = err.Error()
+					fetched = false
 				}
-                if client.Debug {
-                    log.Printf("Saved %s", filename)
-                }
+				outcomes = append(outcomes, outcome)
+				continue
+			}
+
+			if err := os.MkdirAll(dir, 0755); err != nil {
+				return err
+			}
+
+			// The previous version is kept in history/ when the figures changed
+			changed, err := saveSnapshot(dir, report.Name, reportData, dryRun)
+			if err != nil {
+				return err
+			}
+			if changed {
+				outcome.Outcome = reportSaved
+				log.Printf("Report %s changed, saved new version.", report.Name)
 			} else {
-				log.Printf("[Dry Run] Would save report: %s", filename)
+				outcome.Outcome = reportUnchanged
+				if client.Debug {
+					log.Printf("Report %s unchanged.", report.Name)
+				}
+			}
+			outcome.Warning = checkVariant(reportsDir, report, reportData)
+			outcomes = append(outcomes, outcome)
+		}
+
+		if fetched && year.Closed && !dryRun && selection == nil {
+			if due {
+				stateManager.UpdateReportsFetched(year.Name, now.Format(time.RFC3339))
+			}
+			if variantKey != "" {
+				stateManager.UpdateReportsFetched(year.Name+variantKey, now.Format(time.RFC3339))
+			}
+		}
+	}
+
+	if !dryRun && selection == nil {
+		stateManager.UpdateReports(now.Format(time.RFC3339))
+		if err := stateManager.Save(); err != nil {
+			return err
+		}
+	}
+
+	return summarizeReports(outDir, outcomes, dryRun)
+}
+
+// reportRequest is a single report to fetch for an accounting year
+type reportRequest struct {
+	Type   string
+	Params url.Values
+	// Dir is relative to reports/; Name is the file name without extension
+	Dir  string
+	Name string
+	// Baseline is the report (relative to reports/, without extension) a variant must differ from
+	// if Dinero applied Checked, the parameters that make it a variant; empty for default reports
+	Baseline string
+	Checked  []string
+}
+
+// yearReports lists the reports to fetch for an accounting year: the yearly reports, and result
+// and balance per month or quarter if periods are selected. The default reports are always fetched;
+// a variant selected with the report options is fetched in addition, under its own file names.
+func yearReports(year FiscalYear, options ReportOptions, now time.Time) []reportRequest {
+	variants := []ReportOptions{{}}
+	if options.suffix() != "" {
+		variants = append(variants, options)
+	}
+
+	var reports []reportRequest
+	for _, variant := range variants {
+		for _, str := range reportTypes {
+			report := reportRequest{
+				Type:   str,
+				Params: variant.params(),
+				Name:   fmt.Sprintf("%s_%s%s", year.FileName(), str, variant.suffix()),
+			}
+			if variant.suffix() != "" {
+				report.Baseline = fmt.Sprintf("%s_%s", year.FileName(), str)
+				report.Checked = paramNames(variant.params())
+			}
+			reports = append(reports, report)
+		}
+	}
+
+	for _, period := range reportPeriods(year, options.Periods, now) {
+		for _, variant := range variants {
+			for _, str := range periodicReportTypes {
+				params := variant.params()
+				params.Set("fromDate", period.From.Format("2006-01-02"))
+				params.Set("toDate", period.To.Format("2006-01-02"))
+				reports = append(reports, reportRequest{
+					Type:   str,
+					Params: params,
+					Dir:    filepath.Join("periods", year.FileName()),
+					Name:   fmt.Sprintf("%s_%s%s", str, period.Name, variant.suffix()),
+					// A period only differs from the whole year if the period was applied
+					Baseline: fmt.Sprintf("%s_%s%s", year.FileName(), str, variant.suffix()),
+					Checked:  []string{"fromDate", "toDate"},
+				})
 			}
 		}
 	}
-	return nil
+
+	return reports
+}
+
+// variantReports returns the reports that are only fetched because of the report options
+func variantReports(reports []reportRequest) []reportRequest {
+	var result []reportRequest
+	for _, report := range reports {
+		if report.Baseline != "" {
+			result = append(result, report)
+		}
+	}
+	return result
+}
+
+// paramNames returns the names of the query parameters, sorted
+func paramNames(params url.Values) []string {
+	names := make([]string, 0, len(params))
+	for name := range params {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// checkVariant compares a fetched variant with its baseline report on disk. An identical report
+// suggests Dinero ignored the variant's parameters, which is returned as a warning. The figures can
+// legitimately be the same (e.g. no accounts without movements), so this doesn't fail the run.
+func checkVariant(reportsDir string, report reportRequest, data []byte) string {
+	if report.Baseline == "" {
+		return ""
+	}
+	baseline, err := os.ReadFile(filepath.Join(reportsDir, report.Baseline+".json"))
+	if err != nil {
+		return ""
+	}
+
+	var a, b bytes.Buffer
+	if json.Compact(&a, data) != nil || json.Compact(&b, baseline) != nil || !bytes.Equal(a.Bytes(), b.Bytes()) {
+		return ""
+	}
+	return fmt.Sprintf("identical to %s; Dinero may have ignored %s", report.Baseline, strings.Join(report.Checked, ", "))
+}
+
+// isReportYearDue reports whether an accounting year's reports should be fetched. Years Dinero
+// doesn't report as closed or locked are always fetched. A closed year is fetched once after it
+// closed, and again whenever its entries changed since that fetch.
+func isReportYearDue(stateManager *state.Manager, outDir string, year FiscalYear) bool {
+	if !year.Closed {
+		return true
+	}
+
+	lastFetched, err := time.Parse(time.RFC3339, stateManager.GetReportsFetched(year.Name))
+	if err != nil {
+		return true
+	}
+
+	changed, ok := latestEntryChange(outDir, year)
+	return ok && changed.After(lastFetched)
 }
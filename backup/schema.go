@@ -0,0 +1,335 @@
+package backup
+
+import (
+	"bytes"
+	"embed"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+//go:embed schemas/*.json
+var schemaFS embed.FS
+
+// Kinds of schema drift
+const (
+	driftUnknownField = "unknown-field"
+	driftMissingField = "missing-field"
+	driftTypeChanged  = "type-changed"
+	// driftMissingMergeKey is an item without the field the backup merges on; such items are kept
+	// as they are instead of being matched to a stored item
+	driftMissingMergeKey = "missing-merge-key"
+)
+
+// resourceSchema is the subset of JSON Schema used to describe the items of an API response.
+// x-mergeKeys lists fields the backup merges on; x-mergeKeyExempt maps a field to the values
+// marking items that legitimately have no merge keys (e.g. primo entries); x-alternatives lists
+// groups of fields where Dinero has returned one name or the other.
+type resourceSchema struct {
+	Title          string                    `json:"title"`
+	Required       []string                  `json:"required"`
+	MergeKeys      []string                  `json:"x-mergeKeys"`
+	MergeKeyExempt map[string][]string       `json:"x-mergeKeyExempt"`
+	Alternatives   [][]string                `json:"x-alternatives"`
+	Properties     map[string]schemaProperty `json:"properties"`
+}
+
+type schemaProperty struct {
+	Type schemaTypes `json:"type"`
+}
+
+// schemaTypes accepts both "type": "string" and "type": ["string", "null"]
+type schemaTypes []string
+
+func (t *schemaTypes) UnmarshalJSON(data []byte) error {
+	var single string
+	if err := json.Unmarshal(data, &single); err == nil {
+		*t = schemaTypes{single}
+		return nil
+	}
+	var multiple []string
+	if err := json.Unmarshal(data, &multiple); err != nil {
+		return err
+	}
+	*t = multiple
+	return nil
+}
+
+// SchemaIssue is a difference between an API response and the expected schema
+type SchemaIssue struct {
+	Resource    string `json:"Resource"`
+	Field       string `json:"Field"`
+	Kind        string `json:"Kind"`
+	Expected    string `json:"Expected,omitempty"`
+	Actual      string `json:"Actual,omitempty"`
+	Count       int    `json:"Count"`
+	FirstSeenAt string `json:"FirstSeenAt"`
+}
+
+// SchemaDriftReport collects schema issues found during a run
+type SchemaDriftReport struct {
+	GeneratedAt string         `json:"GeneratedAt"`
+	Issues      []*SchemaIssue `json:"Issues"`
+}
+
+var (
+	schemaCache = make(map[string]*resourceSchema)
+	schemaDrift = make(map[string]*SchemaIssue)
+)
+
+// loadSchema returns the embedded schema for a resource
+func loadSchema(resource string) (*resourceSchema, error) {
+	if schema, ok := schemaCache[resource]; ok {
+		return schema, nil
+	}
+
+	data, err := schemaFS.ReadFile("schemas/" + resource + ".json")
+	if err != nil {
+		return nil, fmt.Errorf("no schema for %s: %w", resource, err)
+	}
+
+	var schema resourceSchema
+	if err := json.Unmarshal(data, &schema); err != nil {
+		return nil, fmt.Errorf("invalid schema for %s: %w", resource, err)
+	}
+
+	schemaCache[resource] = &schema
+	return &schema, nil
+}
+
+// validateResponse checks every item of an API response against the resource's schema.
+// Unknown, missing and type-changed fields and missing merge keys are recorded for the drift report.
+// If fields is set, only those fields are expected, as the request selected them.
+func validateResponse(resource string, data []byte, fields []string) error {
+	schema, err := loadSchema(resource)
+	if err != nil {
+		return err
+	}
+
+	expected := schema.Properties
+	if fields != nil {
+		expected = make(map[string]schemaProperty)
+		for _, name := range fields {
+			expected[name] = schema.Properties[name]
+		}
+	}
+
+	items, err := responseItems(data)
+	if err != nil {
+		return fmt.Errorf("failed to parse %s response for validation: %w", resource, err)
+	}
+
+	for _, item := range items {
+		exempt := schema.isMergeKeyExempt(item)
+		if !exempt {
+			for _, key := range schema.MergeKeys {
+				if value, ok := item[key]; !ok || value == nil || value == "" {
+					recordSchemaIssue(resource, key, driftMissingMergeKey, "", jsonType(value))
+				}
+			}
+		}
+
+		for field, value := range item {
+			property, known := expected[field]
+			if !known {
+				recordSchemaIssue(resource, field, driftUnknownField, "", jsonType(value))
+				continue
+			}
+			if actual := jsonType(value); len(property.Type) > 0 && !property.Type.allows(actual) {
+				recordSchemaIssue(resource, field, driftTypeChanged, strings.Join(property.Type, "|"), actual)
+			}
+		}
+
+		for field, property := range expected {
+			if _, ok := item[field]; ok || schema.hasAlternative(field, item) || schema.isMergeKey(field) {
+				continue
+			}
+			recordSchemaIssue(resource, field, driftMissingField, strings.Join(property.Type, "|"), "")
+		}
+	}
+
+	return nil
+}
+
+// isMergeKeyExempt reports whether an item is of a kind that has no merge keys
+func (s *resourceSchema) isMergeKeyExempt(item map[string]any) bool {
+	for field, values := range s.MergeKeyExempt {
+		value, _ := item[field].(string)
+		for _, exempt := range values {
+			if value == exempt {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (s *resourceSchema) isMergeKey(field string) bool {
+	for _, key := range s.MergeKeys {
+		if key == field {
+			return true
+		}
+	}
+	return false
+}
+
+// hasAlternative reports whether the item contains another field from the same alternative group
+func (s *resourceSchema) hasAlternative(field string, item map[string]any) bool {
+	for _, group := range s.Alternatives {
+		inGroup := false
+		for _, name := range group {
+			if name == field {
+				inGroup = true
+			}
+		}
+		if !inGroup {
+			continue
+		}
+		for _, name := range group {
+			if _, ok := item[name]; ok {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (t schemaTypes) allows(actual string) bool {
+	for _, allowed := range t {
+		if allowed == actual || (allowed == "number" && actual == "integer") {
+			return true
+		}
+	}
+	return false
+}
+
+// responseItems extracts the items of a plain array, a paginated collection or a single object
+func responseItems(data []byte) ([]map[string]any, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+
+	var value any
+	if err := dec.Decode(&value); err != nil {
+		return nil, err
+	}
+
+	var list []any
+	switch v := value.(type) {
+	case []any:
+		list = v
+	case map[string]any:
+		if collection, ok := v["Collection"].([]any); ok {
+			list = collection
+		} else {
+			list = []any{v}
+		}
+	default:
+		return nil, fmt.Errorf("unexpected response type %s", jsonType(value))
+	}
+
+	items := make([]map[string]any, 0, len(list))
+	for _, item := range list {
+		if obj, ok := item.(map[string]any); ok {
+			items = append(items, obj)
+		}
+	}
+	return items, nil
+}
+
+// jsonType returns the JSON Schema type name of a decoded value
+func jsonType(value any) string {
+	switch v := value.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case string:
+		return "string"
+	case json.Number:
+		if strings.ContainsAny(v.String(), ".eE") {
+			return "number"
+		}
+		return "integer"
+	case []any:
+		return "array"
+	case map[string]any:
+		return "object"
+	default:
+		return fmt.Sprintf("%T", value)
+	}
+}
+
+func recordSchemaIssue(resource, field, kind, expected, actual string) {
+	key := strings.Join([]string{resource, field, kind, actual}, "|")
+	if issue, ok := schemaDrift[key]; ok {
+		issue.Count++
+		return
+	}
+
+	schemaDrift[key] = &SchemaIssue{
+		Resource:    resource,
+		Field:       field,
+		Kind:        kind,
+		Expected:    expected,
+		Actual:      actual,
+		Count:       1,
+		FirstSeenAt: time.Now().UTC().Format(time.RFC3339),
+	}
+	switch kind {
+	case driftUnknownField:
+		log.Printf("Schema drift in %s: unknown field %s (%s)", resource, field, actual)
+	case driftMissingField:
+		log.Printf("Schema drift in %s: missing field %s", resource, field)
+	case driftMissingMergeKey:
+		log.Printf("Warning: schema drift in %s: items without merge key %s are kept unmerged (API changed?)", resource, field)
+	default:
+		log.Printf("Schema drift in %s: field %s changed type from %s to %s", resource, field, expected, actual)
+	}
+}
+
+// SaveSchemaDriftReport writes the schema issues found during the run to drift/schema_<timestamp>.json.
+// Nothing is written if all responses matched their schemas.
+func SaveSchemaDriftReport(outDir string, dryRun bool) error {
+	if len(schemaDrift) == 0 {
+		return nil
+	}
+
+	report := SchemaDriftReport{GeneratedAt: time.Now().UTC().Format(time.RFC3339)}
+	for _, issue := range schemaDrift {
+		report.Issues = append(report.Issues, issue)
+	}
+	sort.Slice(report.Issues, func(i, j int) bool {
+		a, b := report.Issues[i], report.Issues[j]
+		if a.Resource != b.Resource {
+			return a.Resource < b.Resource
+		}
+		if a.Field != b.Field {
+			return a.Field < b.Field
+		}
+		return a.Kind < b.Kind
+	})
+
+	filename := filepath.Join(outDir, "drift", fmt.Sprintf("schema_%s.json", time.Now().Format("20060102150405")))
+	if dryRun {
+		log.Printf("[Dry Run] Would save schema drift report with %d issues to %s", len(report.Issues), filename)
+		return nil
+	}
+
+	data, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(filename), 0755); err != nil {
+		return err
+	}
+	if err := os.WriteFile(filename, data, 0644); err != nil {
+		return err
+	}
+	log.Printf("Schema drift detected: %d issues saved to %s", len(report.Issues), filename)
+	return nil
+}
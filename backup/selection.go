@@ -0,0 +1,111 @@
+package backup
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/rostved/dinero-backup/dinero"
+)
+
+// Selection restricts a run to a period (run --year, --from, --to). A selective run
+// re-pulls the period in full and leaves the incremental cursors in state.json untouched.
+type Selection struct {
+	From time.Time
+	To   time.Time
+}
+
+// ResolveSelection turns the --year, --from and --to options into a Selection.
+// year is an accounting year name; from and to are dates (YYYY-MM-DD) and default to the
+// start of the first accounting year and today. Returns nil if no option is set.
+func ResolveSelection(client *dinero.Client, year, from, to string) (*Selection, error) {
+	if year == "" && from == "" && to == "" {
+		return nil, nil
+	}
+	if year != "" && (from != "" || to != "") {
+		return nil, fmt.Errorf("--year can't be combined with --from or --to")
+	}
+
+	years, err := GetAccountingYears(client)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get accounting years: %w", err)
+	}
+
+	if year != "" {
+		var names []string
+		for _, fiscalYear := range years {
+			if fiscalYear.Name == year || fiscalYear.FileName() == year {
+				return &Selection{From: fiscalYear.From, To: fiscalYear.To}, nil
+			}
+			names = append(names, fiscalYear.Name)
+		}
+		return nil, fmt.Errorf("unknown accounting year %q (known: %s)", year, strings.Join(names, ", "))
+	}
+
+	selection := &Selection{To: time.Now().UTC().Truncate(24 * time.Hour)}
+	if len(years) > 0 {
+		selection.From = years[0].From
+	}
+	if from != "" {
+		if selection.From, err = time.Parse("2006-01-02", from); err != nil {
+			return nil, fmt.Errorf("invalid --from date %q, expected YYYY-MM-DD", from)
+		}
+	}
+	if to != "" {
+		if selection.To, err = time.Parse("2006-01-02", to); err != nil {
+			return nil, fmt.Errorf("invalid --to date %q, expected YYYY-MM-DD", to)
+		}
+	}
+	if selection.To.Before(selection.From) {
+		return nil, fmt.Errorf("--from %s is after --to %s", selection.From.Format("2006-01-02"), selection.To.Format("2006-01-02"))
+	}
+
+	return selection, nil
+}
+
+func (s *Selection) String() string {
+	return fmt.Sprintf("%s to %s", s.From.Format("2006-01-02"), s.To.Format("2006-01-02"))
+}
+
+// ContainsDate reports whether a date (YYYY-MM-DD, optionally followed by a time) falls within the selection
+func (s *Selection) ContainsDate(date string) bool {
+	if len(date) < 10 {
+		return false
+	}
+	t, err := time.Parse("2006-01-02", date[:10])
+	if err != nil {
+		return false
+	}
+	return !t.Before(s.From) && !t.After(s.To)
+}
+
+// overlaps reports whether an accounting year shares at least one day with the selection
+func (s *Selection) overlaps(year FiscalYear) bool {
+	return !year.To.Before(s.From) && !year.From.After(s.To)
+}
+
+// clip returns the part of an accounting year that falls within the selection
+func (s *Selection) clip(year FiscalYear) (time.Time, time.Time) {
+	from, to := year.From, year.To
+	if s.From.After(from) {
+		from = s.From
+	}
+	if s.To.Before(to) {
+		to = s.To
+	}
+	return from, to
+}
+
+// selectYears returns the accounting years overlapping the selection, or all years without one
+func selectYears(years []FiscalYear, selection *Selection) []FiscalYear {
+	if selection == nil {
+		return years
+	}
+	var result []FiscalYear
+	for _, year := range years {
+		if selection.overlaps(year) {
+			result = append(result, year)
+		}
+	}
+	return result
+}
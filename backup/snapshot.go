@@ -0,0 +1,53 @@
+package backup
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// saveSnapshot writes data to <dir>/<name>.json if it differs from the current snapshot.
+// The previous version is kept in <dir>/history/<name>_<timestamp>.json so changes can be traced.
+// Returns true if the snapshot changed (or would have changed in dry run mode).
+func saveSnapshot(dir, name string, data []byte, dryRun bool) (bool, error) {
+	// Normalize formatting so whitespace differences in API responses don't count as changes
+	var normalized bytes.Buffer
+	if err := json.Indent(&normalized, data, "", "  "); err != nil {
+		return false, fmt.Errorf("invalid JSON for %s: %w", name, err)
+	}
+
+	filename := filepath.Join(dir, name+".json")
+	existing, err := os.ReadFile(filename)
+	if err == nil {
+		var current bytes.Buffer
+		if json.Indent(&current, existing, "", "  ") == nil && bytes.Equal(current.Bytes(), normalized.Bytes()) {
+			return false, nil
+		}
+	}
+
+	if dryRun {
+		log.Printf("[Dry Run] Would save snapshot: %s", filename)
+		return true, nil
+	}
+
+	// Archive the previous version before overwriting
+	if err == nil {
+		historyDir := filepath.Join(dir, "history")
+		if err := os.MkdirAll(historyDir, 0755); err != nil {
+			return false, err
+		}
+		archived := filepath.Join(historyDir, fmt.Sprintf("%s_%s.json", name, time.Now().UTC().Format("20060102150405")))
+		if err := os.WriteFile(archived, existing, 0644); err != nil {
+			return false, err
+		}
+	}
+
+	if err := os.WriteFile(filename, normalized.Bytes(), 0644); err != nil {
+		return false, err
+	}
+	return true, nil
+}
@@ -1,5 +1,13 @@
 package backup
 
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
 // PaginatedResponse represents Dinero's paginated API response format
 type PaginatedResponse struct {
 	Collection []any `json:"Collection"`
@@ -8,9 +16,10 @@ type PaginatedResponse struct {
 
 // Invoice represents a Dinero invoice
 type Invoice struct {
-	Guid   string `json:"Guid"`
-	Number int    `json:"Number"`
-	Status string `json:"Status"`
+	Guid      string `json:"Guid"`
+	Number    int    `json:"Number"`
+	Status    string `json:"Status"`
+	UpdatedAt string `json:"UpdatedAt"`
 }
 
 // InvoiceResponse represents Dinero's paginated invoice response
@@ -29,17 +38,56 @@ type Entry struct {
 	Description   string  `json:"Description"`
 	VatType       string  `json:"VatType"`
 	VatCode       string  `json:"VatCode"`
-	Amount        float64 `json:"Amount"`
+	Amount        Money   `json:"Amount"`
 	EntryGuid     string  `json:"EntryGuid"`
 	ContactGuid   *string `json:"ContactGuid"`
 	Type          string  `json:"Type"`
+	// TombstonedAt is set when the entry was no longer returned by Dinero.
+	// Tombstoned entries are kept for audit but excluded from exports.
+	TombstonedAt string `json:"TombstonedAt,omitempty"`
+}
+
+// RawEntry is an entry exactly as returned by Dinero. The raw JSON object is what gets stored,
+// so fields added by Dinero (or not modelled by Entry) are never lost; the embedded Entry is
+// decoded from it and only used for merge keys, grouping and conversions.
+type RawEntry struct {
+	Entry
+	Raw json.RawMessage
+}
+
+func (e RawEntry) MarshalJSON() ([]byte, error) {
+	return e.Raw, nil
+}
+
+func (e *RawEntry) UnmarshalJSON(data []byte) error {
+	if err := json.Unmarshal(data, &e.Entry); err != nil {
+		return err
+	}
+	e.Raw = append(json.RawMessage(nil), data...)
+	return nil
+}
+
+// SetTombstoned marks the entry as deleted upstream, updating both the raw object and the decoded entry
+func (e *RawEntry) SetTombstoned(timestamp string) error {
+	value, err := json.Marshal(timestamp)
+	if err != nil {
+		return err
+	}
+	raw, err := setRawField(e.Raw, "TombstonedAt", value)
+	if err != nil {
+		return err
+	}
+	e.Raw = raw
+	e.TombstonedAt = timestamp
+	return nil
 }
 
 // PurchaseVoucher represents a purchase voucher with file reference
 type PurchaseVoucher struct {
-	Guid     string `json:"Guid"`
-	FileGuid string `json:"FileGuid"`
-	Number   int    `json:"Number"`
+	Guid        string `json:"Guid"`
+	FileGuid    string `json:"FileGuid"`
+	Number      int    `json:"Number"`
+	VoucherDate string `json:"VoucherDate"`
 }
 
 // AccountingYear represents a Dinero accounting year
@@ -49,4 +97,91 @@ type AccountingYear struct {
 	ToDate    string `json:"ToDate"`
 	DateEnd   string `json:"dateEnd"`
 	Name      string `json:"name"`
+	// Status is e.g. "Open", "Closed" or "Locked"; IsClosed and IsLocked are the flags some
+	// responses carry instead
+	Status   string `json:"Status"`
+	IsClosed bool   `json:"IsClosed"`
+	IsLocked bool   `json:"IsLocked"`
+}
+
+// FiscalYear is an accounting year with its actual boundaries.
+// Accounting years don't have to follow the calendar year, and the first year
+// of a company may be shorter or longer than 12 months.
+type FiscalYear struct {
+	Name string
+	From time.Time
+	To   time.Time
+	// Closed is set when Dinero reports the year as closed or locked for postings
+	Closed bool
+}
+
+// FiscalYear resolves the boundaries and name of an accounting year.
+// The end date defaults to one year after the start date if missing,
+// and the name defaults to the end year (or "<start>-<end>" if the year spans two calendar years).
+func (y AccountingYear) FiscalYear() (FiscalYear, error) {
+	fromStr := y.FromDate
+	if fromStr == "" {
+		fromStr = y.DateStart
+	}
+	if fromStr == "" {
+		return FiscalYear{}, fmt.Errorf("missing start date")
+	}
+
+	from, err := time.ParseInLocation("2006-01-02", fromStr, time.UTC)
+	if err != nil {
+		return FiscalYear{}, err
+	}
+
+	toStr := y.ToDate
+	if toStr == "" {
+		toStr = y.DateEnd
+	}
+	to := from.AddDate(1, 0, -1)
+	if toStr != "" {
+		to, err = time.ParseInLocation("2006-01-02", toStr, time.UTC)
+		if err != nil {
+			return FiscalYear{}, err
+		}
+	}
+
+	name := y.Name
+	if name == "" {
+		if from.Year() == to.Year() {
+			name = strconv.Itoa(to.Year())
+		} else {
+			name = fmt.Sprintf("%d-%d", from.Year(), to.Year())
+		}
+	}
+
+	status := strings.ToLower(y.Status)
+	closed := y.IsClosed || y.IsLocked || status == "closed" || status == "locked"
+
+	return FiscalYear{Name: name, From: from, To: to, Closed: closed}, nil
+}
+
+// Contains reports whether a date falls within the accounting year (both ends inclusive)
+func (y FiscalYear) Contains(t time.Time) bool {
+	return !t.Before(y.From) && !t.After(y.To)
+}
+
+// IsCalendarYear reports whether the accounting year runs from January 1 to December 31 of the same year
+func (y FiscalYear) IsCalendarYear() bool {
+	return y.From.Month() == time.January && y.From.Day() == 1 &&
+		y.To.Month() == time.December && y.To.Day() == 31 &&
+		y.From.Year() == y.To.Year()
+}
+
+// FileName returns the accounting year name in a form safe for file names
+func (y FiscalYear) FileName() string {
+	return sanitizeFileName(y.Name)
+}
+
+// findFiscalYear returns the accounting year containing a date
+func findFiscalYear(years []FiscalYear, t time.Time) (FiscalYear, bool) {
+	for _, year := range years {
+		if year.Contains(t) {
+			return year, true
+		}
+	}
+	return FiscalYear{}, false
 }
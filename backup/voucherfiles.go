@@ -0,0 +1,257 @@
+package backup
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/rostved/dinero-backup/dinero"
+)
+
+// unassignedDir holds files that couldn't be linked to a voucher
+const unassignedDir = "unassigned"
+
+// voucherSources are the voucher endpoints that reference files, with the
+// VoucherType they appear as on entries
+var voucherSources = []struct {
+	Endpoint    string
+	VoucherType string
+}{
+	{Endpoint: "/v1/{organizationId}/vouchers/purchase", VoucherType: "Purchases"},
+	{Endpoint: "/v1/{organizationId}/vouchers/manuel", VoucherType: "manuel"},
+}
+
+// VoucherRef identifies the voucher (bilag) a file is attached to
+type VoucherRef struct {
+	Year          string `json:"Year"`
+	VoucherType   string `json:"VoucherType"`
+	VoucherNumber int    `json:"VoucherNumber"`
+	VoucherDate   string `json:"VoucherDate,omitempty"`
+}
+
+// VouchersResponse represents Dinero's paginated voucher response
+type VouchersResponse struct {
+	Collection []PurchaseVoucher `json:"Collection"`
+}
+
+// fetchVoucherRefs maps FileGuid to the voucher each file is attached to.
+// Voucher numbers restart every accounting year, so files are grouped by accounting year name.
+func fetchVoucherRefs(client *dinero.Client, outDir string) (map[string]VoucherRef, error) {
+	years, err := GetAccountingYears(client)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get accounting years: %w", err)
+	}
+
+	entryYears := loadVoucherEntryYears(outDir)
+	refs := make(map[string]VoucherRef)
+
+	for _, source := range voucherSources {
+		page := 0
+		pageSize := 100
+
+		for {
+			params := url.Values{}
+			params.Set("fields", "Guid,FileGuid,Number,VoucherDate")
+			params.Set("page", fmt.Sprintf("%d", page))
+			params.Set("pageSize", fmt.Sprintf("%d", pageSize))
+
+			data, err := client.Get(source.Endpoint, params)
+			if err != nil {
+				return nil, fmt.Errorf("failed to fetch %s vouchers: %w", source.VoucherType, err)
+			}
+			if err := validateResponse("vouchers", data, nil); err != nil {
+				return nil, err
+			}
+
+			var response VouchersResponse
+			if err := json.Unmarshal(data, &response); err != nil {
+				return nil, fmt.Errorf("failed to parse %s vouchers: %w", source.VoucherType, err)
+			}
+
+			for _, voucher := range response.Collection {
+				if voucher.FileGuid == "" || voucher.Number == 0 {
+					continue
+				}
+
+				// Prefer the voucher date; fall back to the booked entries for the voucher
+				year := ""
+				if t, err := time.Parse("2006-01-02", voucher.VoucherDate); err == nil {
+					if fiscalYear, ok := findFiscalYear(years, t); ok {
+						year = fiscalYear.FileName()
+					}
+				}
+				if year == "" {
+					if candidates := entryYears[voucherKey(source.VoucherType, voucher.Number)]; len(candidates) == 1 {
+						year = candidates[0]
+					}
+				}
+				if year == "" {
+					continue
+				}
+
+				refs[voucher.FileGuid] = VoucherRef{
+					Year:          year,
+					VoucherType:   source.VoucherType,
+					VoucherNumber: voucher.Number,
+					VoucherDate:   voucher.VoucherDate,
+				}
+			}
+
+			if len(response.Collection) < pageSize {
+				break
+			}
+			page++
+		}
+	}
+
+	return refs, nil
+}
+
+func voucherKey(voucherType string, number int) string {
+	return fmt.Sprintf("%s/%d", voucherType, number)
+}
+
+// loadVoucherEntryYears maps voucher type and number to the accounting years (as used in
+// entries file names) they appear in. Voucher numbers restart every year, so a key may map to several years.
+func loadVoucherEntryYears(outDir string) map[string][]string {
+	result := make(map[string][]string)
+
+	matches, _ := filepath.Glob(filepath.Join(outDir, "entries", "entries_*.json"))
+	for _, match := range matches {
+		year := strings.TrimSuffix(strings.TrimPrefix(filepath.Base(match), "entries_"), ".json")
+		data, err := os.ReadFile(match)
+		if err != nil {
+			continue
+		}
+		var entries []Entry
+		if err := json.Unmarshal(data, &entries); err != nil {
+			continue
+		}
+
+		seen := make(map[string]bool)
+		for _, entry := range entries {
+			if entry.VoucherNumber == nil || entry.VoucherType == nil {
+				continue
+			}
+			key := voucherKey(*entry.VoucherType, *entry.VoucherNumber)
+			if !seen[key] {
+				seen[key] = true
+				result[key] = append(result[key], year)
+			}
+		}
+	}
+
+	return result
+}
+
+// organizeVoucherFiles links every stored file into files/<year>/<voucherType>/<voucherNumber>-<name>,
+// or files/unassigned/<stored name> when it isn't attached to a voucher. Files are hard-linked
+// (copied if the filesystem doesn't support links), so the flat files/ store stays intact.
+func organizeVoucherFiles(index []FileIndexEntry, refs map[string]VoucherRef, outDir string, dryRun bool) {
+	filesDir := filepath.Join(outDir, "files")
+	usedPaths := make(map[string]string)
+	linked, unassigned := 0, 0
+
+	for i := range index {
+		entry := &index[i]
+		if entry.Status == fileStatusDeleted || entry.StoredName == "" {
+			continue
+		}
+
+		var relPath string
+		if ref, ok := refs[entry.FileGuid]; ok {
+			name := fmt.Sprintf("%d-%s", ref.VoucherNumber, sanitizeFileName(entry.OriginalName))
+			relPath = filepath.Join(ref.Year, ref.VoucherType, name)
+			if guid, taken := usedPaths[relPath]; taken && guid != entry.FileGuid {
+				// Two files with the same name on one voucher
+				relPath = filepath.Join(ref.Year, ref.VoucherType, fmt.Sprintf("%d-%s", ref.VoucherNumber, entry.StoredName))
+			}
+			entry.Voucher = &ref
+			linked++
+		} else {
+			relPath = filepath.Join(unassignedDir, entry.StoredName)
+			entry.Voucher = nil
+			unassigned++
+		}
+		usedPaths[relPath] = entry.FileGuid
+
+		source := filepath.Join(filesDir, entry.StoredName)
+		if _, err := os.Stat(source); err != nil {
+			continue
+		}
+
+		// A re-downloaded file replaces the stored file with a new inode, so the existing link is stale
+		if relPath == entry.VoucherPath && linkCurrent(source, filepath.Join(filesDir, relPath)) {
+			continue
+		}
+
+		if dryRun {
+			log.Printf("[Dry Run] Would link %s to %s", entry.StoredName, relPath)
+			continue
+		}
+
+		if err := linkFile(source, filepath.Join(filesDir, relPath)); err != nil {
+			log.Printf("Failed to link %s to %s: %v", entry.StoredName, relPath, err)
+			continue
+		}
+
+		// Remove the previous link, e.g. when a file moved from unassigned to a voucher
+		if entry.VoucherPath != "" && entry.VoucherPath != relPath {
+			os.Remove(filepath.Join(filesDir, entry.VoucherPath))
+		}
+		entry.VoucherPath = relPath
+	}
+
+	log.Printf("Organized files by voucher: %d linked, %d unassigned.", linked, unassigned)
+}
+
+// linkCurrent reports whether target still has the content of source: the same file when hard-linked,
+// or a copy of the same size written after the source was last modified
+func linkCurrent(source, target string) bool {
+	sourceInfo, err := os.Stat(source)
+	if err != nil {
+		return false
+	}
+	targetInfo, err := os.Stat(target)
+	if err != nil {
+		return false
+	}
+	if os.SameFile(sourceInfo, targetInfo) {
+		return true
+	}
+	return sourceInfo.Size() == targetInfo.Size() && !targetInfo.ModTime().Before(sourceInfo.ModTime())
+}
+
+// linkFile hard-links source to target, falling back to a copy
+func linkFile(source, target string) error {
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return err
+	}
+	os.Remove(target)
+
+	if err := os.Link(source, target); err == nil {
+		return nil
+	}
+
+	in, err := os.Open(source)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.Create(target)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
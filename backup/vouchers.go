@@ -3,25 +3,69 @@ package backup
 import (
 	"encoding/json"
 	"fmt"
-	"io"
 	"log"
 	"net/url"
 	"os"
 	"path/filepath"
+	"strings"
 	"time"
 
 	"github.com/rostved/dinero-backup/dinero"
 	"github.com/rostved/dinero-backup/state"
 )
 
+// fileStatuses are the statuses a file in Dinero's file archive can have.
+// Unused files are uploads in the inbox that haven't been attached to a voucher yet.
+var fileStatuses = []string{"Used", "Unused"}
+
+// fileStatusDeleted marks files that are no longer returned by the API for any status
+const fileStatusDeleted = "Deleted"
+
 // File represents a file in Dinero's file archive
 type File struct {
 	FileGuid  string `json:"FileGuid"`
 	FileName  string `json:"FileName"`
 	CreatedAt string `json:"CreatedAt"`
+	Size      int64  `json:"Size"`
+	Status    string `json:"-"`
+}
+
+// FilesResponse represents Dinero's paginated files response
+type FilesResponse struct {
+	Collection []File `json:"Collection"`
+	Pagination struct {
+		Page     int `json:"Page"`
+		PageSize int `json:"PageSize"`
+		// Result is the total number of files across all pages
+		Result int `json:"Result"`
+	} `json:"Pagination"`
+}
+
+// FileIndexEntry describes a backed up file in files/index.json
+type FileIndexEntry struct {
+	FileGuid      string             `json:"FileGuid"`
+	OriginalName  string             `json:"OriginalName"`
+	Status        string             `json:"Status"`
+	UploadedAt    string             `json:"UploadedAt"`
+	Size          int64              `json:"Size"`
+	StoredName    string             `json:"StoredName"`
+	SHA256        string             `json:"SHA256,omitempty"`
+	StatusHistory []FileStatusChange `json:"StatusHistory,omitempty"`
+	Voucher       *VoucherRef        `json:"Voucher,omitempty"`
+	// VoucherPath is the file's location in the voucher layout, relative to files/
+	VoucherPath string `json:"VoucherPath,omitempty"`
+	// CollidesWith lists other files with the same original name but different content
+	CollidesWith []string `json:"CollidesWith,omitempty"`
 }
 
-func BackupVouchers(client *dinero.Client, stateManager *state.Manager, outDir string, dryRun bool) error {
+// FileStatusChange records a status transition observed between two backups
+type FileStatusChange struct {
+	From       string `json:"From"`
+	To         string `json:"To"`
+	DetectedAt string `json:"DetectedAt"`
+}
+
+func BackupVouchers(client *dinero.Client, stateManager *state.Manager, outDir string, dryRun bool, selection *Selection, csvDialect *CSVDialect) error {
 	log.Println("Backing up Files...")
 
 	if !dryRun {
@@ -30,88 +74,109 @@ func BackupVouchers(client *dinero.Client, stateManager *state.Manager, outDir s
 		}
 	}
 
-	lastSync := stateManager.GetLastSyncVouchers()
 	now := time.Now().UTC()
 
-	// Fetch files, filtered by upload date and status
-	params := url.Values{}
-	params.Set("fileStatus", "Used")
-	if lastSync != "" && lastSync != "2000-01-01T00:00:00Z" {
-		params.Set("uploadedAfter", lastSync)
-		log.Printf("Fetching files uploaded after %s", lastSync)
-	}
-
-	data, err := client.Get("/v1/{organizationId}/files", params)
-	if err != nil {
-		return fmt.Errorf("failed to fetch files: %w", err)
-	}
-
+	// Always list the full archive (metadata only) so status transitions of
+	// older files are detected. Only files missing locally are downloaded.
 	var files []File
-	if err := json.Unmarshal(data, &files); err != nil {
-		return fmt.Errorf("failed to parse files response: %w", err)
+	for _, status := range fileStatuses {
+		statusFiles, err := fetchFiles(client, status)
+		if err != nil {
+			return err
+		}
+		files = append(files, statusFiles...)
 	}
 
-	if len(files) == 0 {
+	index, indexErr := loadFileIndex(outDir)
+
+	// With files in the index, an empty archive still has to mark them as deleted
+	if len(files) == 0 && len(index) == 0 {
 		log.Println("No files found (not updating lastSync).")
 		return nil
 	}
 
 	log.Printf("Found %d files.", len(files))
+	if indexErr != nil {
+		log.Printf("No existing file index, creating new one.")
+		index = []FileIndexEntry{}
+	}
+
+	index = mergeFileIndex(index, files, now.Format(time.RFC3339))
+	migrateLegacyFiles(index, outDir, dryRun)
+
+	// Resolve the voucher each file is attached to, used for the layout and for selective runs
+	refs, refsErr := fetchVoucherRefs(client, outDir)
+	if refsErr != nil && selection != nil {
+		return fmt.Errorf("could not resolve vouchers for selection: %w", refsErr)
+	}
+	if selection != nil {
+		log.Printf("Only fetching files attached to vouchers dated %s (selective)", selection)
+	}
 
-	// Download each file
+	// Download each file that isn't stored locally yet
 	downloaded := 0
-	for _, file := range files {
-		filename := file.FileName
-		if filename == "" {
-			filename = file.FileGuid + ".pdf"
+	for i := range index {
+		entry := &index[i]
+		if entry.Status == fileStatusDeleted {
+			continue
 		}
-		filePath := filepath.Join(outDir, "files", filename)
-
-		// Skip if file already exists
-		if _, err := os.Stat(filePath); err == nil {
-			if client.Debug {
-				log.Printf("Skipping existing file: %s", filename)
+		if selection != nil {
+			if ref, ok := refs[entry.FileGuid]; !ok || !selection.ContainsDate(ref.VoucherDate) {
+				continue
 			}
-			continue
 		}
+		filePath := filepath.Join(outDir, "files", entry.StoredName)
 
-		if !dryRun {
-			stream, err := client.GetStream(fmt.Sprintf("/v1/{organizationId}/files/%s", file.FileGuid))
-			if err != nil {
+		// Skip if the file already exists and Dinero still lists the same size and upload date;
+		// otherwise download it again so the previous version is archived next to it
+		if _, err := os.Stat(filePath); err == nil {
+			if !storedFileChanged(entry, filePath, dryRun) {
 				if client.Debug {
-					log.Printf("Failed to download file %s: %v", file.FileGuid, err)
+					log.Printf("Skipping existing file: %s", entry.StoredName)
 				}
 				continue
 			}
+			log.Printf("File %s changed in Dinero, downloading again.", entry.StoredName)
+		}
 
-			outFile, err := os.Create(filePath)
-			if err != nil {
-				stream.Close()
-				log.Printf("Failed to create file %s: %v", filePath, err)
-				continue
-			}
-
-			_, err = io.Copy(outFile, stream)
-			outFile.Close()
-			stream.Close()
-
-			if err != nil {
-				log.Printf("Failed to write file %s: %v", filePath, err)
-			} else {
-				downloaded++
-				if client.Debug {
-					log.Printf("Downloaded: %s", filename)
-				}
-			}
-		} else {
-			log.Printf("[Dry Run] Would download: %s", filename)
+		if dryRun {
+			log.Printf("[Dry Run] Would download: %s", entry.StoredName)
 			downloaded++
+			continue
+		}
+
+		if err := downloadFile(client, entry, filePath); err != nil {
+			log.Printf("Failed to download file %s: %v", entry.FileGuid, err)
+			continue
+		}
+		downloaded++
+		if client.Debug {
+			log.Printf("Downloaded: %s", entry.StoredName)
 		}
 	}
 
 	log.Printf("Downloaded %d files.", downloaded)
 
-	if !dryRun {
+	detectNameCollisions(index)
+
+	// Lay out files by year, voucher type and voucher number for auditors
+	if refsErr != nil {
+		log.Printf("Could not resolve vouchers for files (keeping current layout): %v", refsErr)
+	} else {
+		organizeVoucherFiles(index, refs, outDir, dryRun)
+	}
+
+	if err := saveFileIndex(outDir, index, dryRun); err != nil {
+		return err
+	}
+	if csvDialect != nil {
+		if err := saveFileIndexCSV(outDir, index, csvDialect, dryRun); err != nil {
+			return err
+		}
+	}
+
+	// A selective run doesn't move the incremental cursor
+	if !dryRun && selection == nil {
 		stateManager.UpdateVouchers(now.Format(time.RFC3339))
 		if err := stateManager.Save(); err != nil {
 			return err
@@ -120,3 +185,307 @@ func BackupVouchers(client *dinero.Client, stateManager *state.Manager, outDir s
 
 	return nil
 }
+
+// fetchFiles fetches all pages of files with the given status
+func fetchFiles(client *dinero.Client, status string) ([]File, error) {
+	var files []File
+	page := 0
+	pageSize := 100
+
+	for {
+		params := url.Values{}
+		params.Set("fileStatus", status)
+		params.Set("page", fmt.Sprintf("%d", page))
+		params.Set("pageSize", fmt.Sprintf("%d", pageSize))
+
+		data, err := client.Get("/v1/{organizationId}/files", params)
+		if err != nil {
+			return nil, fmt.Errorf("failed to fetch %s files: %w", status, err)
+		}
+		if err := validateResponse("files", data, nil); err != nil {
+			return nil, err
+		}
+
+		pageFiles, response, err := parseFilesResponse(data)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse files response: %w", err)
+		}
+
+		for i := range pageFiles {
+			pageFiles[i].Status = status
+		}
+		files = append(files, pageFiles...)
+
+		if client.Debug {
+			log.Printf("Fetched %s files page %d: %d files", status, page, len(pageFiles))
+		}
+
+		// A plain array isn't paginated, so it holds every file with the status. Otherwise stop at
+		// the total reported by the API, or at a short page if the total is missing.
+		if response == nil || len(pageFiles) < pageSize {
+			break
+		}
+		if response.Pagination.Result > 0 && len(files) >= response.Pagination.Result {
+			break
+		}
+		page++
+	}
+
+	return files, nil
+}
+
+// parseFilesResponse accepts both a plain array and a paginated collection,
+// as the files endpoint has returned both shapes. The response is nil for a plain array.
+func parseFilesResponse(data []byte) ([]File, *FilesResponse, error) {
+	var files []File
+	if err := json.Unmarshal(data, &files); err == nil {
+		return files, nil, nil
+	}
+
+	var response FilesResponse
+	if err := json.Unmarshal(data, &response); err != nil {
+		return nil, nil, err
+	}
+	return response.Collection, &response, nil
+}
+
+func loadFileIndex(outDir string) ([]FileIndexEntry, error) {
+	data, err := os.ReadFile(filepath.Join(outDir, "files", "index.json"))
+	if err != nil {
+		return nil, err
+	}
+
+	var index []FileIndexEntry
+	if err := json.Unmarshal(data, &index); err != nil {
+		return nil, err
+	}
+
+	return index, nil
+}
+
+func saveFileIndex(outDir string, index []FileIndexEntry, dryRun bool) error {
+	filename := filepath.Join(outDir, "files", "index.json")
+	if dryRun {
+		log.Printf("[Dry Run] Would save file index with %d files to %s", len(index), filename)
+		return nil
+	}
+
+	data, err := json.MarshalIndent(index, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal file index: %w", err)
+	}
+	return os.WriteFile(filename, data, 0644)
+}
+
+// mergeFileIndex updates the index with the files currently listed by the API.
+// Preserves existing order, appends new files at the end and records status transitions.
+// Files no longer listed under any status are marked as deleted.
+func mergeFileIndex(index []FileIndexEntry, files []File, now string) []FileIndexEntry {
+	fileMap := make(map[string]File)
+	for _, f := range files {
+		fileMap[f.FileGuid] = f
+	}
+
+	setStatus := func(entry *FileIndexEntry, status string) {
+		if entry.Status != status {
+			entry.StatusHistory = append(entry.StatusHistory, FileStatusChange{
+				From:       entry.Status,
+				To:         status,
+				DetectedAt: now,
+			})
+			entry.Status = status
+		}
+	}
+
+	// Track stored names in use so a new file never reuses another file's name
+	storedNames := make(map[string]string)
+	for _, entry := range index {
+		if entry.StoredName != "" {
+			storedNames[entry.StoredName] = entry.FileGuid
+		}
+	}
+	assignStoredName := func(entry *FileIndexEntry) {
+		name := storedFileName(entry.OriginalName, entry.FileGuid, false)
+		if guid, taken := storedNames[name]; taken && guid != entry.FileGuid {
+			name = storedFileName(entry.OriginalName, entry.FileGuid, true)
+		}
+		entry.StoredName = name
+		storedNames[name] = entry.FileGuid
+	}
+
+	applied := make(map[string]bool)
+	for i := range index {
+		entry := &index[i]
+		if entry.StoredName == "" {
+			assignStoredName(entry)
+		}
+		f, ok := fileMap[entry.FileGuid]
+		if !ok {
+			setStatus(entry, fileStatusDeleted)
+			continue
+		}
+		applied[f.FileGuid] = true
+		setStatus(entry, f.Status)
+		if f.FileName != "" {
+			entry.OriginalName = f.FileName
+		}
+		if f.Size > 0 {
+			entry.Size = f.Size
+		}
+		if f.CreatedAt != "" {
+			entry.UploadedAt = f.CreatedAt
+		}
+	}
+
+	for _, f := range files {
+		if applied[f.FileGuid] {
+			continue
+		}
+		applied[f.FileGuid] = true
+
+		entry := FileIndexEntry{
+			FileGuid:     f.FileGuid,
+			OriginalName: f.FileName,
+			Status:       f.Status,
+			UploadedAt:   f.CreatedAt,
+			Size:         f.Size,
+		}
+		assignStoredName(&entry)
+		index = append(index, entry)
+	}
+
+	return index
+}
+
+// migrateLegacyFiles renames files stored under their raw original name by earlier versions to
+// their collision-safe stored name, so they aren't downloaded again and left behind as orphans.
+// A legacy file is only claimed by a file with the same name and (when known) the same size; files
+// that shared a name were overwritten back then, so the others are downloaded again.
+func migrateLegacyFiles(index []FileIndexEntry, outDir string, dryRun bool) {
+	filesDir := filepath.Join(outDir, "files")
+	storedNames := make(map[string]bool)
+	for _, entry := range index {
+		storedNames[entry.StoredName] = true
+	}
+
+	claimed := make(map[string]bool)
+	migrated := 0
+	for i := range index {
+		entry := &index[i]
+		name := entry.OriginalName
+		if entry.Status == fileStatusDeleted || name == "" || name != filepath.Base(name) || name == "." || name == ".." ||
+			name == "index.json" || storedNames[name] || claimed[name] {
+			continue
+		}
+		target := filepath.Join(filesDir, entry.StoredName)
+		if _, err := os.Stat(target); err == nil {
+			continue
+		}
+		legacy := filepath.Join(filesDir, name)
+		info, err := os.Stat(legacy)
+		if err != nil || !info.Mode().IsRegular() || (entry.Size > 0 && info.Size() != entry.Size) {
+			continue
+		}
+
+		claimed[name] = true
+		if dryRun {
+			log.Printf("[Dry Run] Would rename %s to %s", name, entry.StoredName)
+			continue
+		}
+		if err := os.Rename(legacy, target); err != nil {
+			log.Printf("Failed to rename %s to %s: %v", name, entry.StoredName, err)
+			continue
+		}
+		migrated++
+	}
+
+	if migrated > 0 {
+		log.Printf("Renamed %d files from their original names to collision-safe names.", migrated)
+	}
+}
+
+// downloadFile downloads a file to filePath, recording its size and checksum
+// in the index entry and in a metadata sidecar next to the file
+func downloadFile(client *dinero.Client, entry *FileIndexEntry, filePath string) error {
+	endpoint := fmt.Sprintf("/v1/{organizationId}/files/%s", entry.FileGuid)
+	download, err := client.GetStream(endpoint)
+	if err != nil {
+		return err
+	}
+
+	meta, _, err := downloadVersionedDocument(download, endpoint, entry.FileGuid, entry.OriginalName, entry.UploadedAt, filePath)
+	if err != nil {
+		return err
+	}
+
+	entry.Size = meta.Size
+	entry.SHA256 = meta.SHA256
+	return nil
+}
+
+// storedFileChanged reports whether a file on disk no longer matches the size or upload date Dinero lists
+// for it. Unchanged files get their checksum recorded in the index, and sidecars written before upload
+// dates were recorded are backfilled.
+func storedFileChanged(entry *FileIndexEntry, filePath string, dryRun bool) bool {
+	endpoint := fmt.Sprintf("/v1/{organizationId}/files/%s", entry.FileGuid)
+	var meta *DocumentMeta
+	var err error
+	if dryRun {
+		meta, err = loadDocumentMeta(filePath)
+	} else {
+		meta, err = ensureDocumentMeta(endpoint, entry.FileGuid, entry.OriginalName, filePath)
+	}
+	if err != nil {
+		return false
+	}
+
+	if (entry.Size > 0 && meta.Size != entry.Size) ||
+		(meta.SourceUpdatedAt != "" && entry.UploadedAt != "" && meta.SourceUpdatedAt != entry.UploadedAt) {
+		return true
+	}
+
+	entry.Size = meta.Size
+	entry.SHA256 = meta.SHA256
+	if meta.SourceUpdatedAt == "" && entry.UploadedAt != "" && !dryRun {
+		meta.SourceUpdatedAt = entry.UploadedAt
+		if err := saveDocumentMeta(filePath, meta); err != nil {
+			log.Printf("Failed to update metadata for %s: %v", entry.StoredName, err)
+		}
+	}
+	return false
+}
+
+// detectNameCollisions flags files that share an original name but have different content,
+// e.g. two different receipts both uploaded as "scan.pdf"
+func detectNameCollisions(index []FileIndexEntry) {
+	byName := make(map[string][]int)
+	for i, entry := range index {
+		if entry.SHA256 == "" {
+			continue
+		}
+		name := strings.ToLower(entry.OriginalName)
+		byName[name] = append(byName[name], i)
+	}
+
+	for i := range index {
+		index[i].CollidesWith = nil
+	}
+
+	for name, positions := range byName {
+		if len(positions) < 2 {
+			continue
+		}
+		reported := false
+		for _, i := range positions {
+			for _, j := range positions {
+				if i != j && index[i].SHA256 != index[j].SHA256 {
+					index[i].CollidesWith = append(index[i].CollidesWith, index[j].FileGuid)
+				}
+			}
+			if len(index[i].CollidesWith) > 0 && !reported {
+				log.Printf("Warning: %d files named %q have different content", len(positions), name)
+				reported = true
+			}
+		}
+	}
+}
@@ -3,9 +3,11 @@ package dinero
 import (
 	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
+	"net"
 	"net/http"
 	"net/url"
 	"strings"
@@ -27,6 +29,39 @@ type Client struct {
     Debug        bool
 }
 
+// Download is a streamed file download with the response metadata needed for sidecars
+type Download struct {
+	Body        io.ReadCloser
+	ContentType string
+}
+
+// APIError is returned when the API responds with an error status code
+type APIError struct {
+	StatusCode int
+	Body       string
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("request failed with status code %d: %s", e.StatusCode, e.Body)
+}
+
+// IsNotFound reports whether err is an API error with status 404
+func IsNotFound(err error) bool {
+	var apiErr *APIError
+	return errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusNotFound
+}
+
+// IsTransient reports whether a request that failed with err may succeed when retried:
+// rate limiting, server errors and network timeouts
+func IsTransient(err error) bool {
+	var apiErr *APIError
+	if errors.As(err, &apiErr) {
+		return apiErr.StatusCode == http.StatusTooManyRequests || apiErr.StatusCode >= 500
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
+
 type TokenResponse struct {
 	AccessToken string `json:"access_token"`
 	ExpiresIn   int    `json:"expires_in"`
@@ -139,7 +174,7 @@ func (c *Client) doRequest(method, endpoint string, params url.Values, stream bo
     if resp.StatusCode >= 400 {
         body, _ := io.ReadAll(resp.Body)
         resp.Body.Close()
-        return nil, fmt.Errorf("request failed with status code %d: %s", resp.StatusCode, string(body))
+        return nil, &APIError{StatusCode: resp.StatusCode, Body: string(body)}
     }
 
 	return resp, nil
@@ -154,15 +189,15 @@ func (c *Client) Get(endpoint string, params url.Values) ([]byte, error) {
 	return io.ReadAll(resp.Body)
 }
 
-func (c *Client) GetStream(endpoint string) (io.ReadCloser, error) {
+func (c *Client) GetStream(endpoint string) (*Download, error) {
 	resp, err := c.doRequest("GET", endpoint, nil, true)
 	if err != nil {
 		return nil, err
 	}
-	return resp.Body, nil
+	return &Download{Body: resp.Body, ContentType: resp.Header.Get("Content-Type")}, nil
 }
 
-func (c *Client) GetPDF(endpoint string) (io.ReadCloser, error) {
+func (c *Client) GetPDF(endpoint string) (*Download, error) {
 	if c.Token == "" {
 		if err := c.Authenticate(); err != nil {
 			return nil, err
@@ -205,8 +240,8 @@ func (c *Client) GetPDF(endpoint string) (io.ReadCloser, error) {
 	if resp.StatusCode >= 400 {
 		body, _ := io.ReadAll(resp.Body)
 		resp.Body.Close()
-		return nil, fmt.Errorf("request failed with status code %d: %s", resp.StatusCode, string(body))
+		return nil, &APIError{StatusCode: resp.StatusCode, Body: string(body)}
 	}
 
-	return resp.Body, nil
+	return &Download{Body: resp.Body, ContentType: resp.Header.Get("Content-Type")}, nil
 }
@@ -0,0 +1,145 @@
+package export
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/rostved/dinero-backup/backup"
+)
+
+// Account is an account from the chart of accounts
+type Account struct {
+	Number int
+	Name   string
+	// Category is Dinero's account category, if the account data has one
+	Category string
+	VatCode  string
+	// Deposit is set for bank and cash accounts (accounts/deposit)
+	Deposit bool
+}
+
+// Accounts returns the chart of accounts from the organization snapshot, completed with accounts
+// that only appear on entries (e.g. when the snapshot is missing), sorted by number
+func (b *Backup) Accounts(entries []backup.Entry) []Account {
+	accounts := make(map[int]*Account)
+
+	for _, source := range []struct {
+		Name    string
+		Deposit bool
+	}{{"accounts_entry", false}, {"accounts_deposit", true}} {
+		data, err := os.ReadFile(filepath.Join(b.Dir, "organization", source.Name+".json"))
+		if err != nil {
+			continue
+		}
+		var items []map[string]any
+		if err := json.Unmarshal(data, &items); err != nil {
+			continue
+		}
+		for _, item := range items {
+			number, ok := item["AccountNumber"].(float64)
+			if !ok {
+				continue
+			}
+			accounts[int(number)] = &Account{
+				Number:   int(number),
+				Name:     stringField(item, "Name", "AccountName"),
+				Category: stringField(item, "Category", "CategoryName", "AccountType"),
+				VatCode:  stringField(item, "VatCode"),
+				Deposit:  source.Deposit,
+			}
+		}
+	}
+
+	for _, entry := range entries {
+		if _, ok := accounts[entry.AccountNumber]; !ok {
+			accounts[entry.AccountNumber] = &Account{Number: entry.AccountNumber, Name: entry.AccountName}
+		}
+	}
+
+	result := make([]Account, 0, len(accounts))
+	for _, account := range accounts {
+		result = append(result, *account)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Number < result[j].Number
+	})
+	return result
+}
+
+// isPrimo reports whether an entry is an opening balance (primo) entry
+func isPrimo(entry backup.Entry) bool {
+	return entry.Type == "Primo"
+}
+
+// accountBalances sums entries per account into opening balances (primo entries) and closing balances.
+// Amounts are positive for debit and negative for credit, as in Dinero.
+func accountBalances(entries []backup.Entry) (opening, closing map[int]backup.Money) {
+	opening = make(map[int]backup.Money)
+	closing = make(map[int]backup.Money)
+	for _, entry := range entries {
+		if isPrimo(entry) {
+			opening[entry.AccountNumber] += entry.Amount
+		}
+		closing[entry.AccountNumber] += entry.Amount
+	}
+	return opening, closing
+}
+
+// balanceAccounts returns the accounts that belong to the balance sheet rather than the income
+// statement. Dinero's account data doesn't say, so it is derived from the backup: deposit
+// accounts, accounts with primo entries in any accounting year, and accounts listed in saved
+// balance reports.
+func (b *Backup) balanceAccounts(accounts []Account) map[int]bool {
+	balance := make(map[int]bool)
+	for _, account := range accounts {
+		if account.Deposit {
+			balance[account.Number] = true
+		}
+	}
+
+	for _, year := range b.Years {
+		entries, err := backup.LoadEntries(b.Dir, year)
+		if err != nil {
+			continue
+		}
+		for _, entry := range entries {
+			if isPrimo(entry) {
+				balance[entry.AccountNumber] = true
+			}
+		}
+	}
+
+	matches, _ := filepath.Glob(filepath.Join(b.Dir, "reports", "*_balance*.json"))
+	for _, match := range matches {
+		data, err := os.ReadFile(match)
+		if err != nil {
+			continue
+		}
+		var report any
+		if err := json.Unmarshal(data, &report); err != nil {
+			continue
+		}
+		collectAccountNumbers(report, balance)
+	}
+
+	return balance
+}
+
+// collectAccountNumbers adds the AccountNumber of every object nested in a report
+func collectAccountNumbers(value any, numbers map[int]bool) {
+	switch v := value.(type) {
+	case map[string]any:
+		if number, ok := v["AccountNumber"].(float64); ok {
+			numbers[int(number)] = true
+		}
+		for _, child := range v {
+			collectAccountNumbers(child, numbers)
+		}
+	case []any:
+		for _, child := range v {
+			collectAccountNumbers(child, numbers)
+		}
+	}
+}
@@ -0,0 +1,234 @@
+// Package export converts a local backup into formats for accountants, auditors and analysis tools.
+// Exports only read the backup directory; they never call the Dinero API.
+package export
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/rostved/dinero-backup/backup"
+)
+
+// Backup is a local backup directory as written by the run command
+type Backup struct {
+	Dir   string
+	Years []backup.FiscalYear
+}
+
+// Contact is a contact from contacts/contacts.json
+type Contact struct {
+	ContactGuid       string `json:"ContactGuid"`
+	Name              string `json:"Name"`
+	ExternalReference string `json:"ExternalReference"`
+	IsPerson          bool   `json:"IsPerson"`
+	Street            string `json:"Street"`
+	ZipCode           string `json:"ZipCode"`
+	City              string `json:"City"`
+	CountryKey        string `json:"CountryKey"`
+	Phone             string `json:"Phone"`
+	Email             string `json:"Email"`
+	VatNumber         string `json:"VatNumber"`
+	EanNumber         string `json:"EanNumber"`
+	CreatedAt         string `json:"CreatedAt"`
+	UpdatedAt         string `json:"UpdatedAt"`
+	DeletedAt         string `json:"DeletedAt"`
+}
+
+// Invoice is the latest backed up version of an invoice from invoices/
+type Invoice struct {
+	Guid              string       `json:"Guid"`
+	Number            int          `json:"Number"`
+	Status            string       `json:"Status"`
+	Date              string       `json:"Date"`
+	PaymentDate       string       `json:"PaymentDate"`
+	ContactGuid       string       `json:"ContactGuid"`
+	ContactName       string       `json:"ContactName"`
+	Description       string       `json:"Description"`
+	ExternalReference string       `json:"ExternalReference"`
+	Currency          string       `json:"Currency"`
+	TotalExclVat      backup.Money `json:"TotalExclVat"`
+	TotalInclVat      backup.Money `json:"TotalInclVat"`
+	CreatedAt         string       `json:"CreatedAt"`
+	UpdatedAt         string       `json:"UpdatedAt"`
+	DeletedAt         string       `json:"DeletedAt"`
+}
+
+// Open reads the accounting years of a backup. They come from the organization snapshot,
+// so the backup must have been run with the organization backup at least once.
+func Open(dir string) (*Backup, error) {
+	filename := filepath.Join(dir, "organization", "accountingyears.json")
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, fmt.Errorf("no accounting years in %s (run the backup with --organization first): %w", filename, err)
+	}
+
+	years, err := backup.ParseAccountingYears(data, false)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", filename, err)
+	}
+
+	return &Backup{Dir: dir, Years: years}, nil
+}
+
+// Year returns the accounting year with the given name (or file name, e.g. 2023_24)
+func (b *Backup) Year(name string) (backup.FiscalYear, error) {
+	var names []string
+	for _, year := range b.Years {
+		if year.Name == name || year.FileName() == name {
+			return year, nil
+		}
+		names = append(names, year.Name)
+	}
+	return backup.FiscalYear{}, fmt.Errorf("unknown accounting year %q (known: %s)", name, strings.Join(names, ", "))
+}
+
+// Entries returns the entries of an accounting year, without entries deleted in Dinero
+func (b *Backup) Entries(year backup.FiscalYear) ([]backup.Entry, error) {
+	entries, err := backup.LoadEntries(b.Dir, year)
+	if err != nil {
+		return nil, fmt.Errorf("no entries backed up for year %s: %w", year.Name, err)
+	}
+	return entries, nil
+}
+
+// Contacts returns the backed up contacts, without deleted contacts
+func (b *Backup) Contacts() ([]Contact, error) {
+	contacts, err := b.allContacts()
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]Contact, 0, len(contacts))
+	for _, contact := range contacts {
+		if contact.DeletedAt == "" {
+			result = append(result, contact)
+		}
+	}
+	return result, nil
+}
+
+// allContacts returns the backed up contacts, including contacts deleted in Dinero
+func (b *Backup) allContacts() ([]Contact, error) {
+	data, err := os.ReadFile(filepath.Join(b.Dir, "contacts", "contacts.json"))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var contacts []Contact
+	if err := json.Unmarshal(data, &contacts); err != nil {
+		return nil, fmt.Errorf("failed to parse contacts: %w", err)
+	}
+	return contacts, nil
+}
+
+// Files returns the file index from files/index.json, including deleted files
+func (b *Backup) Files() ([]backup.FileIndexEntry, error) {
+	data, err := os.ReadFile(filepath.Join(b.Dir, "files", "index.json"))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var files []backup.FileIndexEntry
+	if err := json.Unmarshal(data, &files); err != nil {
+		return nil, fmt.Errorf("failed to parse file index: %w", err)
+	}
+	return files, nil
+}
+
+// Invoices returns the latest version of every backed up invoice, sorted by number.
+// Each backup run saves the changed invoices as a new invoices_<timestamp>.json, so later
+// files take precedence. Invoices deleted in Dinero are left out.
+func (b *Backup) Invoices() ([]Invoice, error) {
+	latest := make(map[string]Invoice)
+	if err := readInvoiceSnapshots(filepath.Join(b.Dir, "invoices", "invoices_*.json"), func(invoice Invoice) {
+		latest[invoice.Guid] = invoice
+	}); err != nil {
+		return nil, err
+	}
+	if err := readInvoiceSnapshots(filepath.Join(b.Dir, "deleted", "invoices", "deleted_invoices_*.json"), func(invoice Invoice) {
+		delete(latest, invoice.Guid)
+	}); err != nil {
+		return nil, err
+	}
+
+	invoices := make([]Invoice, 0, len(latest))
+	for _, invoice := range latest {
+		if invoice.DeletedAt == "" {
+			invoices = append(invoices, invoice)
+		}
+	}
+	sort.Slice(invoices, func(i, j int) bool {
+		if invoices[i].Number != invoices[j].Number {
+			return invoices[i].Number < invoices[j].Number
+		}
+		return invoices[i].Guid < invoices[j].Guid
+	})
+	return invoices, nil
+}
+
+// readInvoiceSnapshots calls fn for every invoice in the snapshot files matching pattern, oldest file first
+func readInvoiceSnapshots(pattern string, fn func(Invoice)) error {
+	matches, err := filepath.Glob(pattern)
+	if err != nil {
+		return err
+	}
+	// Timestamps in the file names sort chronologically
+	sort.Strings(matches)
+
+	for _, match := range matches {
+		data, err := os.ReadFile(match)
+		if err != nil {
+			return err
+		}
+		var response struct {
+			Collection []Invoice `json:"Collection"`
+		}
+		if err := json.Unmarshal(data, &response); err != nil {
+			return fmt.Errorf("failed to parse %s: %w", match, err)
+		}
+		for _, invoice := range response.Collection {
+			if invoice.Guid != "" {
+				fn(invoice)
+			}
+		}
+	}
+	return nil
+}
+
+// organizationSettings reads a snapshot from organization/ as a generic object.
+// Returns an empty map if the snapshot doesn't exist.
+func (b *Backup) organizationSettings(name string) map[string]any {
+	settings := make(map[string]any)
+	data, err := os.ReadFile(filepath.Join(b.Dir, "organization", name+".json"))
+	if err == nil {
+		json.Unmarshal(data, &settings)
+	}
+	return settings
+}
+
+// stringField returns the first non-empty string value among keys
+func stringField(object map[string]any, keys ...string) string {
+	for _, key := range keys {
+		if value, ok := object[key]; ok {
+			switch v := value.(type) {
+			case string:
+				if v != "" {
+					return v
+				}
+			case float64:
+				return fmt.Sprintf("%.0f", v)
+			}
+		}
+	}
+	return ""
+}
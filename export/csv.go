@@ -0,0 +1,22 @@
+package export
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/rostved/dinero-backup/backup"
+)
+
+// EntriesCSV writes the entries of an accounting year as CSV in the given dialect
+func EntriesCSV(b *Backup, year backup.FiscalYear, dialect *backup.CSVDialect, path string) error {
+	data, err := os.ReadFile(backup.EntriesPath(b.Dir, year))
+	if err != nil {
+		return fmt.Errorf("no entries backed up for year %s: %w", year.Name, err)
+	}
+
+	csvData, err := backup.EntriesToCSV(data, dialect)
+	if err != nil {
+		return err
+	}
+	return writeFile(path, string(csvData))
+}
@@ -0,0 +1,335 @@
+package export
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/rostved/dinero-backup/backup"
+)
+
+// plainTextCurrency is the commodity of all amounts; Dinero entries are in the base currency
+const plainTextCurrency = "DKK"
+
+// openingBalancesAccount balances primo entries that don't sum to zero
+const openingBalancesAccount = "Equity:Opening-Balances"
+
+// plainTextJournal is an accounting year prepared for the plain-text accounting formats
+type plainTextJournal struct {
+	Title    string
+	Start    string
+	Accounts []string
+	// Opening holds the primo entries, or is nil if the year has none
+	Opening      *plainTextTransaction
+	Transactions []plainTextTransaction
+}
+
+// plainTextTransaction is a voucher with a posting per entry
+type plainTextTransaction struct {
+	Date      string
+	Narration string
+	Voucher   string
+	Postings  []plainTextPosting
+}
+
+type plainTextPosting struct {
+	Account string
+	Amount  backup.Money
+}
+
+// Beancount writes an accounting year as a Beancount file
+func Beancount(b *Backup, year backup.FiscalYear, path string) error {
+	journal, err := b.plainTextJournal(year)
+	if err != nil {
+		return err
+	}
+
+	var out strings.Builder
+	fmt.Fprintf(&out, "option \"title\" %s\n", beancountString(journal.Title))
+	fmt.Fprintf(&out, "option \"operating_currency\" \"%s\"\n\n", plainTextCurrency)
+
+	for _, account := range journal.Accounts {
+		fmt.Fprintf(&out, "%s open %s %s\n", journal.Start, account, plainTextCurrency)
+	}
+
+	transactions := journal.Transactions
+	if journal.Opening != nil {
+		transactions = append([]plainTextTransaction{*journal.Opening}, transactions...)
+	}
+	for _, t := range transactions {
+		fmt.Fprintf(&out, "\n%s * %s\n", t.Date, beancountString(t.Narration))
+		if t.Voucher != "" {
+			fmt.Fprintf(&out, "  voucher: %s\n", beancountString(t.Voucher))
+		}
+		for _, posting := range t.Postings {
+			fmt.Fprintf(&out, "  %s  %s %s\n", posting.Account, posting.Amount, plainTextCurrency)
+		}
+	}
+
+	return writeFile(path, out.String())
+}
+
+// Ledger writes an accounting year as a ledger-cli journal, which hledger reads as well
+func Ledger(b *Backup, year backup.FiscalYear, path string) error {
+	journal, err := b.plainTextJournal(year)
+	if err != nil {
+		return err
+	}
+
+	var out strings.Builder
+	fmt.Fprintf(&out, "; %s\n\n", ledgerText(journal.Title))
+	fmt.Fprintf(&out, "commodity %s\n", plainTextCurrency)
+	for _, account := range journal.Accounts {
+		fmt.Fprintf(&out, "account %s\n", account)
+	}
+
+	transactions := journal.Transactions
+	if journal.Opening != nil {
+		transactions = append([]plainTextTransaction{*journal.Opening}, transactions...)
+	}
+	for _, t := range transactions {
+		fmt.Fprintf(&out, "\n%s * %s\n", t.Date, ledgerText(t.Narration))
+		if t.Voucher != "" {
+			fmt.Fprintf(&out, "    ; Voucher: %s\n", ledgerText(t.Voucher))
+		}
+		for _, posting := range t.Postings {
+			fmt.Fprintf(&out, "    %s  %s %s\n", posting.Account, posting.Amount, plainTextCurrency)
+		}
+	}
+
+	return writeFile(path, out.String())
+}
+
+// plainTextJournal groups the entries of a year into a transaction per voucher, with
+// opening balances from the primo entries. It fails if a transaction doesn't balance, as
+// the target tools can't load such a file.
+func (b *Backup) plainTextJournal(year backup.FiscalYear) (*plainTextJournal, error) {
+	entries, err := b.Entries(year)
+	if err != nil {
+		return nil, err
+	}
+
+	accounts := b.Accounts(entries)
+	balanceAccounts := b.balanceAccounts(accounts)
+	_, closing := accountBalances(entries)
+	names := plainTextAccountNames(accounts, balanceAccounts, closing)
+
+	company := b.organizationSettings("company")
+	organization := b.organizationSettings("organization")
+	journal := &plainTextJournal{
+		Title: fmt.Sprintf("%s %s", nonEmpty(stringField(company, "Name", "CompanyName"), stringField(organization, "Name"), "Dinero"), year.Name),
+		Start: year.From.Format("2006-01-02"),
+	}
+
+	used := make(map[string]bool)
+	opening := make(map[string]backup.Money)
+	transactions := make(map[string][]backup.Entry)
+	for _, entry := range entries {
+		name := names[entry.AccountNumber]
+		used[name] = true
+		if isPrimo(entry) {
+			opening[name] += entry.Amount
+			continue
+		}
+		key := transactionKey(entry)
+		transactions[key] = append(transactions[key], entry)
+	}
+
+	if len(opening) > 0 {
+		t := plainTextTransaction{Date: journal.Start, Narration: "Primo"}
+		var sum backup.Money
+		for _, account := range sortedAccountNames(opening) {
+			t.Postings = append(t.Postings, plainTextPosting{Account: account, Amount: opening[account]})
+			sum += opening[account]
+		}
+		if sum != 0 {
+			t.Postings = append(t.Postings, plainTextPosting{Account: openingBalancesAccount, Amount: -sum})
+			used[openingBalancesAccount] = true
+		}
+		journal.Opening = &t
+	}
+
+	var unbalanced []string
+	for _, key := range sortedTransactionKeys(transactions) {
+		voucherEntries := transactions[key]
+		first := voucherEntries[0]
+		t := plainTextTransaction{
+			Date:      entryDate(first.Date),
+			Narration: first.Description,
+		}
+		if first.VoucherNumber != nil {
+			t.Voucher = fmt.Sprintf("%s %d", backup.VoucherTypeName(first.VoucherType, first.Type), *first.VoucherNumber)
+		}
+
+		var sum backup.Money
+		for _, entry := range voucherEntries {
+			t.Postings = append(t.Postings, plainTextPosting{Account: names[entry.AccountNumber], Amount: entry.Amount})
+			sum += entry.Amount
+		}
+		if sum != 0 {
+			unbalanced = append(unbalanced, fmt.Sprintf("%s on %s (off by %s)", nonEmpty(t.Voucher, strconv.Quote(t.Narration)), t.Date, sum))
+		}
+		journal.Transactions = append(journal.Transactions, t)
+	}
+
+	// Beancount and ledger both reject a file with an unbalanced transaction
+	if len(unbalanced) > 0 {
+		shown := unbalanced
+		if len(shown) > 5 {
+			shown = shown[:5]
+		}
+		return nil, fmt.Errorf("%d transactions in year %s don't balance: %s", len(unbalanced), year.Name, strings.Join(shown, "; "))
+	}
+
+	for name := range used {
+		journal.Accounts = append(journal.Accounts, name)
+	}
+	sort.Strings(journal.Accounts)
+
+	return journal, nil
+}
+
+// accountRoots maps words in Dinero's account categories to the root accounts of plain-text accounting
+var accountRoots = []struct {
+	Keyword string
+	Root    string
+}{
+	{"egenkapital", "Equity"},
+	{"equity", "Equity"},
+	{"gæld", "Liabilities"},
+	{"passiv", "Liabilities"},
+	{"liabilit", "Liabilities"},
+	{"aktiv", "Assets"},
+	{"asset", "Assets"},
+	{"omsætning", "Income"},
+	{"indtægt", "Income"},
+	{"revenue", "Income"},
+	{"income", "Income"},
+	{"udgift", "Expenses"},
+	{"omkostning", "Expenses"},
+	{"forbrug", "Expenses"},
+	{"expense", "Expenses"},
+}
+
+// plainTextAccountNames names every account Root:Category:Number-Name. The root comes from the
+// account's category if it names one, or from the account name if it names a root of the right
+// kind (balance sheet or income statement). Otherwise balance accounts with a debit balance are
+// assets and with a credit balance liabilities, and income statement accounts with a credit
+// balance are income and with a debit balance expenses.
+func plainTextAccountNames(accounts []Account, balanceAccounts map[int]bool, closing map[int]backup.Money) map[int]string {
+	names := make(map[int]string)
+	for _, account := range accounts {
+		balance := balanceAccounts[account.Number]
+		root := matchAccountRoot(account.Category)
+		if root == "" {
+			if byName := matchAccountRoot(account.Name); byName != "" && isBalanceRoot(byName) == balance {
+				root = byName
+			}
+		}
+		if root == "" {
+			credit := closing[account.Number] < 0
+			switch {
+			case balance && credit:
+				root = "Liabilities"
+			case balance:
+				root = "Assets"
+			case credit:
+				root = "Income"
+			default:
+				root = "Expenses"
+			}
+		}
+
+		parts := []string{root}
+		if component := accountComponent(account.Category); component != "" {
+			parts = append(parts, component)
+		}
+		leaf := strconv.Itoa(account.Number)
+		if component := accountComponent(account.Name); component != "" {
+			leaf += "-" + component
+		}
+		names[account.Number] = strings.Join(append(parts, leaf), ":")
+	}
+	return names
+}
+
+// matchAccountRoot returns the root account named by a category or account name, if any
+func matchAccountRoot(name string) string {
+	name = strings.ToLower(name)
+	for _, candidate := range accountRoots {
+		if strings.Contains(name, candidate.Keyword) {
+			return candidate.Root
+		}
+	}
+	return ""
+}
+
+func isBalanceRoot(root string) bool {
+	return root == "Assets" || root == "Liabilities" || root == "Equity"
+}
+
+var accountTransliterations = strings.NewReplacer("æ", "ae", "ø", "oe", "å", "aa", "Æ", "Ae", "Ø", "Oe", "Å", "Aa")
+
+// accountComponent turns a name into an account name component both Beancount and ledger accept:
+// ASCII letters, digits and dashes, starting with a capital letter or digit
+func accountComponent(name string) string {
+	name = accountTransliterations.Replace(name)
+	var b strings.Builder
+	dash := false
+	for _, r := range name {
+		if r <= unicode.MaxASCII && (unicode.IsLetter(r) || unicode.IsDigit(r)) {
+			if dash && b.Len() > 0 {
+				b.WriteByte('-')
+			}
+			b.WriteRune(r)
+			dash = false
+		} else {
+			dash = true
+		}
+	}
+	component := b.String()
+	if component == "" {
+		return ""
+	}
+	return strings.ToUpper(component[:1]) + component[1:]
+}
+
+func sortedAccountNames(m map[string]backup.Money) []string {
+	names := make([]string, 0, len(m))
+	for name := range m {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// entryDate returns the date part (YYYY-MM-DD) of an entry date
+func entryDate(date string) string {
+	if len(date) > 10 {
+		return date[:10]
+	}
+	return date
+}
+
+var beancountEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\r\n", " ", "\n", " ", "\r", " ")
+
+func beancountString(s string) string {
+	return `"` + beancountEscaper.Replace(s) + `"`
+}
+
+var ledgerEscaper = strings.NewReplacer("\r\n", " ", "\n", " ", "\r", " ")
+
+func ledgerText(s string) string {
+	return ledgerEscaper.Replace(s)
+}
+
+func writeFile(path, content string) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(path, []byte(content), 0644)
+}
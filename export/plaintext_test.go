@@ -0,0 +1,169 @@
+package export
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rostved/dinero-backup/backup"
+)
+
+// testBackup writes entries for one accounting year to a temporary backup directory
+func testBackup(t *testing.T, entries string) (*Backup, backup.FiscalYear) {
+	t.Helper()
+	dir := t.TempDir()
+	year := backup.FiscalYear{
+		Name: "2025",
+		From: time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC),
+		To:   time.Date(2025, 12, 31, 0, 0, 0, 0, time.UTC),
+	}
+	path := backup.EntriesPath(dir, year)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(entries), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return &Backup{Dir: dir, Years: []backup.FiscalYear{year}}, year
+}
+
+func TestPlainTextJournalGrouping(t *testing.T) {
+	tests := []struct {
+		name    string
+		entries string
+		// want lists the date, narration and posting count of each transaction; transactions
+		// on the same date are ordered by their key, so the comparison ignores their order
+		want []string
+	}{
+		{
+			name: "voucher entries form one transaction",
+			entries: `[
+				{"AccountNumber": 1000, "Date": "2025-03-01", "VoucherNumber": 1, "VoucherType": "Manuel", "Description": "Sale", "Amount": -100},
+				{"AccountNumber": 5000, "Date": "2025-03-01", "VoucherNumber": 1, "VoucherType": "Manuel", "Description": "Sale, bank", "Amount": 100}
+			]`,
+			want: []string{"2025-03-01 Sale/2"},
+		},
+		{
+			name: "same voucher number with different types stays apart",
+			entries: `[
+				{"AccountNumber": 1000, "Date": "2025-03-01", "VoucherNumber": 1, "VoucherType": "Manuel", "Description": "A", "Amount": -100},
+				{"AccountNumber": 5000, "Date": "2025-03-01", "VoucherNumber": 1, "VoucherType": "Manuel", "Description": "A", "Amount": 100},
+				{"AccountNumber": 1000, "Date": "2025-03-01", "VoucherNumber": 1, "VoucherType": "Invoice", "Description": "B", "Amount": -50},
+				{"AccountNumber": 5000, "Date": "2025-03-01", "VoucherNumber": 1, "VoucherType": "Invoice", "Description": "B", "Amount": 50}
+			]`,
+			want: []string{"2025-03-01 A/2", "2025-03-01 B/2"},
+		},
+		{
+			name: "voucherless entries group by date and description",
+			entries: `[
+				{"AccountNumber": 1000, "Date": "2025-04-01T00:00:00", "Description": "Fee", "Amount": -25},
+				{"AccountNumber": 5000, "Date": "2025-04-01", "Description": "Fee", "Amount": 25},
+				{"AccountNumber": 1000, "Date": "2025-04-01", "Description": "Interest", "Amount": -10},
+				{"AccountNumber": 5000, "Date": "2025-04-01", "Description": "Interest", "Amount": 10},
+				{"AccountNumber": 1000, "Date": "2025-04-02", "Description": "Fee", "Amount": -25},
+				{"AccountNumber": 5000, "Date": "2025-04-02", "Description": "Fee", "Amount": 25}
+			]`,
+			want: []string{"2025-04-01 Fee/2", "2025-04-01 Interest/2", "2025-04-02 Fee/2"},
+		},
+		{
+			name: "primo entries become the opening transaction",
+			entries: `[
+				{"AccountNumber": 5000, "Date": "2025-01-01", "Description": "Primo", "Amount": 300, "Type": "Primo"},
+				{"AccountNumber": 1000, "Date": "2025-03-01", "VoucherNumber": 1, "VoucherType": "Manuel", "Description": "Sale", "Amount": -100},
+				{"AccountNumber": 5000, "Date": "2025-03-01", "VoucherNumber": 1, "VoucherType": "Manuel", "Description": "Sale", "Amount": 100}
+			]`,
+			want: []string{"2025-03-01 Sale/2"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b, year := testBackup(t, tt.entries)
+			journal, err := b.plainTextJournal(year)
+			if err != nil {
+				t.Fatalf("plainTextJournal returned error: %v", err)
+			}
+
+			var got []string
+			for i, transaction := range journal.Transactions {
+				if i > 0 && transaction.Date < journal.Transactions[i-1].Date {
+					t.Errorf("transaction on %s sorted after %s", transaction.Date, journal.Transactions[i-1].Date)
+				}
+				got = append(got, fmt.Sprintf("%s %s/%d", transaction.Date, transaction.Narration, len(transaction.Postings)))
+			}
+			sort.Strings(got)
+			if strings.Join(got, ", ") != strings.Join(tt.want, ", ") {
+				t.Errorf("transactions = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPlainTextJournalOpening(t *testing.T) {
+	b, year := testBackup(t, `[
+		{"AccountNumber": 5000, "Date": "2025-01-01", "Description": "Primo", "Amount": 300, "Type": "Primo"},
+		{"AccountNumber": 6000, "Date": "2025-01-01", "Description": "Primo", "Amount": -100, "Type": "Primo"}
+	]`)
+
+	journal, err := b.plainTextJournal(year)
+	if err != nil {
+		t.Fatalf("plainTextJournal returned error: %v", err)
+	}
+	if journal.Opening == nil {
+		t.Fatal("expected an opening transaction")
+	}
+
+	var sum backup.Money
+	balanced := false
+	for _, posting := range journal.Opening.Postings {
+		sum += posting.Amount
+		if posting.Account == openingBalancesAccount && posting.Amount == -20000 {
+			balanced = true
+		}
+	}
+	if sum != 0 || !balanced {
+		t.Errorf("opening postings = %+v, want them balanced against %s", journal.Opening.Postings, openingBalancesAccount)
+	}
+}
+
+func TestPlainTextJournalUnbalanced(t *testing.T) {
+	tests := []struct {
+		name    string
+		entries string
+		want    string
+	}{
+		{
+			name: "voucher",
+			entries: `[
+				{"AccountNumber": 1000, "Date": "2025-03-01", "VoucherNumber": 7, "VoucherType": "Manuel", "Description": "Sale", "Amount": -100},
+				{"AccountNumber": 5000, "Date": "2025-03-01", "VoucherNumber": 7, "VoucherType": "Manuel", "Description": "Sale", "Amount": 99.99}
+			]`,
+			want: "on 2025-03-01 (off by -0.01)",
+		},
+		{
+			name: "voucherless entries with different descriptions",
+			entries: `[
+				{"AccountNumber": 1000, "Date": "2025-04-01", "Description": "Fee", "Amount": -25},
+				{"AccountNumber": 5000, "Date": "2025-04-01", "Description": "Fee, bank", "Amount": 25}
+			]`,
+			want: `"Fee" on 2025-04-01 (off by -25.00)`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b, year := testBackup(t, tt.entries)
+			_, err := b.plainTextJournal(year)
+			if err == nil {
+				t.Fatal("plainTextJournal returned no error for an unbalanced transaction")
+			}
+			if !strings.Contains(err.Error(), "don't balance") || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("error = %q, want it to contain %q", err, tt.want)
+			}
+		})
+	}
+}
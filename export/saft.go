@@ -0,0 +1,617 @@
+package export
+
+import (
+	"crypto/sha256"
+	_ "embed"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime/debug"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rostved/dinero-backup/backup"
+)
+
+//go:embed schemas/saft_financial_dk.xsd
+var saftSchema []byte
+
+type saftAuditFile struct {
+	XMLName              xml.Name                 `xml:"urn:StandardAuditFile-Taxation-Financial:DK AuditFile"`
+	Header               saftHeader               `xml:"Header"`
+	MasterFiles          saftMasterFiles          `xml:"MasterFiles"`
+	GeneralLedgerEntries saftGeneralLedgerEntries `xml:"GeneralLedgerEntries"`
+	SourceDocuments      *saftSourceDocuments     `xml:"SourceDocuments,omitempty"`
+}
+
+type saftHeader struct {
+	AuditFileVersion     string        `xml:"AuditFileVersion"`
+	AuditFileCountry     string        `xml:"AuditFileCountry"`
+	AuditFileDateCreated string        `xml:"AuditFileDateCreated"`
+	SoftwareCompanyName  string        `xml:"SoftwareCompanyName"`
+	SoftwareID           string        `xml:"SoftwareID"`
+	SoftwareVersion      string        `xml:"SoftwareVersion"`
+	Company              saftCompany   `xml:"Company"`
+	DefaultCurrencyCode  string        `xml:"DefaultCurrencyCode"`
+	SelectionCriteria    saftSelection `xml:"SelectionCriteria"`
+	TaxAccountingBasis   string        `xml:"TaxAccountingBasis"`
+}
+
+type saftCompany struct {
+	RegistrationNumber string              `xml:"RegistrationNumber"`
+	Name               string              `xml:"Name"`
+	Address            saftAddress         `xml:"Address"`
+	Contact            saftContact         `xml:"Contact"`
+	TaxRegistration    saftTaxRegistration `xml:"TaxRegistration"`
+}
+
+type saftContact struct {
+	FirstName string `xml:"ContactPerson>FirstName"`
+	LastName  string `xml:"ContactPerson>LastName"`
+	Telephone string `xml:"Telephone,omitempty"`
+	Email     string `xml:"Email,omitempty"`
+}
+
+type saftTaxRegistration struct {
+	TaxRegistrationNumber string `xml:"TaxRegistrationNumber"`
+	TaxType               string `xml:"TaxType,omitempty"`
+	TaxAuthority          string `xml:"TaxAuthority,omitempty"`
+}
+
+type saftAddress struct {
+	StreetName string `xml:"StreetName,omitempty"`
+	City       string `xml:"City,omitempty"`
+	PostalCode string `xml:"PostalCode,omitempty"`
+	Country    string `xml:"Country,omitempty"`
+}
+
+type saftSelection struct {
+	SelectionStartDate string `xml:"SelectionStartDate"`
+	SelectionEndDate   string `xml:"SelectionEndDate"`
+}
+
+type saftMasterFiles struct {
+	Accounts  []saftAccount  `xml:"GeneralLedgerAccounts>Account"`
+	Customers []saftCustomer `xml:"Customers>Customer,omitempty"`
+	Suppliers []saftSupplier `xml:"Suppliers>Supplier,omitempty"`
+}
+
+type saftAccount struct {
+	AccountID            string `xml:"AccountID"`
+	AccountDescription   string `xml:"AccountDescription"`
+	GroupingCategory     string `xml:"GroupingCategory"`
+	GroupingCode         string `xml:"GroupingCode"`
+	AccountType          string `xml:"AccountType"`
+	OpeningDebitBalance  string `xml:"OpeningDebitBalance,omitempty"`
+	OpeningCreditBalance string `xml:"OpeningCreditBalance,omitempty"`
+	ClosingDebitBalance  string `xml:"ClosingDebitBalance,omitempty"`
+	ClosingCreditBalance string `xml:"ClosingCreditBalance,omitempty"`
+}
+
+type saftCustomer struct {
+	RegistrationNumber string       `xml:"RegistrationNumber,omitempty"`
+	Name               string       `xml:"Name"`
+	Address            *saftAddress `xml:"Address,omitempty"`
+	CustomerID         string       `xml:"CustomerID"`
+}
+
+type saftSupplier struct {
+	RegistrationNumber string       `xml:"RegistrationNumber,omitempty"`
+	Name               string       `xml:"Name"`
+	Address            *saftAddress `xml:"Address,omitempty"`
+	SupplierID         string       `xml:"SupplierID"`
+}
+
+type saftGeneralLedgerEntries struct {
+	NumberOfEntries int           `xml:"NumberOfEntries"`
+	TotalDebit      string        `xml:"TotalDebit"`
+	TotalCredit     string        `xml:"TotalCredit"`
+	Journals        []saftJournal `xml:"Journal"`
+}
+
+type saftJournal struct {
+	JournalID    string            `xml:"JournalID"`
+	Description  string            `xml:"Description"`
+	Type         string            `xml:"Type"`
+	Transactions []saftTransaction `xml:"Transaction"`
+}
+
+type saftTransaction struct {
+	TransactionID   string     `xml:"TransactionID"`
+	Period          int        `xml:"Period"`
+	PeriodYear      int        `xml:"PeriodYear"`
+	TransactionDate string     `xml:"TransactionDate"`
+	Description     string     `xml:"Description"`
+	SystemEntryDate string     `xml:"SystemEntryDate"`
+	GLPostingDate   string     `xml:"GLPostingDate"`
+	Lines           []saftLine `xml:"Line"`
+}
+
+type saftLine struct {
+	RecordID     string      `xml:"RecordID"`
+	AccountID    string      `xml:"AccountID"`
+	CustomerID   string      `xml:"CustomerID,omitempty"`
+	SupplierID   string      `xml:"SupplierID,omitempty"`
+	Description  string      `xml:"Description"`
+	DebitAmount  *saftAmount `xml:"DebitAmount,omitempty"`
+	CreditAmount *saftAmount `xml:"CreditAmount,omitempty"`
+}
+
+type saftAmount struct {
+	Amount string `xml:"Amount"`
+}
+
+type saftSourceDocuments struct {
+	SalesInvoices saftSalesInvoices `xml:"SalesInvoices"`
+}
+
+type saftSalesInvoices struct {
+	NumberOfEntries int           `xml:"NumberOfEntries"`
+	TotalDebit      string        `xml:"TotalDebit"`
+	TotalCredit     string        `xml:"TotalCredit"`
+	Invoices        []saftInvoice `xml:"Invoice"`
+}
+
+type saftInvoice struct {
+	InvoiceNo    string `xml:"InvoiceNo"`
+	CustomerInfo struct {
+		CustomerID string `xml:"CustomerID,omitempty"`
+		Name       string `xml:"Name"`
+	} `xml:"CustomerInfo"`
+	InvoiceDate    string `xml:"InvoiceDate"`
+	DocumentTotals struct {
+		NetTotal   string `xml:"NetTotal"`
+		GrossTotal string `xml:"GrossTotal"`
+	} `xml:"DocumentTotals"`
+}
+
+// journals are the SAF-T journals entries are grouped into, by Dinero voucher type
+var journals = []struct {
+	VoucherType string
+	ID          string
+	Type        string
+}{
+	{VoucherType: "Sales", ID: "SALG", Type: "S"},
+	{VoucherType: "Purchases", ID: "KOEB", Type: "P"},
+	{VoucherType: "manuel", ID: "FINANS", Type: "G"},
+}
+
+// otherJournalID collects entries with any other voucher type
+const otherJournalID = "OEVRIGE"
+
+// SAFT writes a SAF-T Financial file for an accounting year. The company contact and account grouping
+// come from the mapping; if any required data is missing from the backup or the mapping, the export
+// fails listing all of it. The structure is checked against the tool's own subset schema, which catches
+// mistakes in the writer but isn't the official Danish XSD, so it is no proof the file is valid for
+// submission. The file is only written if the check passes.
+func SAFT(b *Backup, year backup.FiscalYear, mapping *SAFTMapping, path string) error {
+	entries, err := b.Entries(year)
+	if err != nil {
+		return err
+	}
+	firstSeen, err := backup.FirstSeen(b.Dir, year)
+	if err != nil {
+		return err
+	}
+	contacts, err := b.Contacts()
+	if err != nil {
+		return err
+	}
+	invoices, err := b.Invoices()
+	if err != nil {
+		return err
+	}
+
+	contactsByGuid := make(map[string]Contact)
+	for _, contact := range contacts {
+		contactsByGuid[contact.ContactGuid] = contact
+	}
+
+	var missing missingSAFTData
+	file := saftAuditFile{Header: b.saftHeader(year, mapping, &missing)}
+
+	opening, closing := accountBalances(entries)
+	var ungrouped []string
+	for _, account := range b.Accounts(entries) {
+		if _, used := closing[account.Number]; !used {
+			continue
+		}
+		accountID := strconv.Itoa(account.Number)
+		grouping := mapping.Accounts[accountID]
+		if grouping.GroupingCategory == "" || grouping.GroupingCode == "" {
+			ungrouped = append(ungrouped, accountID)
+		}
+		saftAcc := saftAccount{
+			AccountID:          accountID,
+			AccountDescription: truncate(account.Name, 256),
+			GroupingCategory:   truncate(grouping.GroupingCategory, 35),
+			GroupingCode:       truncate(grouping.GroupingCode, 35),
+			AccountType:        "GL",
+		}
+		saftAcc.OpeningDebitBalance, saftAcc.OpeningCreditBalance = debitCredit(opening[account.Number])
+		saftAcc.ClosingDebitBalance, saftAcc.ClosingCreditBalance = debitCredit(closing[account.Number])
+		file.MasterFiles.Accounts = append(file.MasterFiles.Accounts, saftAcc)
+	}
+
+	if len(ungrouped) > 0 {
+		missing.add("grouping category and code in the mapping for accounts %s", strings.Join(ungrouped, ", "))
+	}
+
+	customers := make(map[string]string)
+	suppliers := make(map[string]string)
+	file.GeneralLedgerEntries = saftLedger(year, entries, firstSeen, customers, suppliers, &missing)
+
+	var salesInvoices saftSalesInvoices
+	var totalCredit backup.Money
+	for _, invoice := range invoices {
+		if invoice.Status == "Draft" || !containsDate(year, invoice.Date) {
+			continue
+		}
+		saftInv := saftInvoice{InvoiceNo: strconv.Itoa(invoice.Number), InvoiceDate: entryDate(invoice.Date)}
+		saftInv.CustomerInfo.CustomerID = truncate(invoice.ContactGuid, 35)
+		saftInv.CustomerInfo.Name = truncate(nonEmpty(invoice.ContactName, "Ukendt"), 70)
+		saftInv.DocumentTotals.NetTotal = formatAmount(invoice.TotalExclVat)
+		saftInv.DocumentTotals.GrossTotal = formatAmount(invoice.TotalInclVat)
+		salesInvoices.Invoices = append(salesInvoices.Invoices, saftInv)
+		totalCredit += invoice.TotalExclVat
+		if invoice.ContactGuid != "" {
+			customers[invoice.ContactGuid] = invoice.ContactName
+		}
+	}
+	if len(salesInvoices.Invoices) > 0 {
+		salesInvoices.NumberOfEntries = len(salesInvoices.Invoices)
+		salesInvoices.TotalDebit = formatAmount(0)
+		salesInvoices.TotalCredit = formatAmount(totalCredit)
+		file.SourceDocuments = &saftSourceDocuments{SalesInvoices: salesInvoices}
+	}
+
+	for _, guid := range sortedKeys(customers) {
+		contact := contactsByGuid[guid]
+		file.MasterFiles.Customers = append(file.MasterFiles.Customers, saftCustomer{
+			RegistrationNumber: truncate(contact.VatNumber, 35),
+			Name:               truncate(nonEmpty(contact.Name, customers[guid], "Ukendt"), 70),
+			Address:            contactAddress(contact),
+			CustomerID:         truncate(guid, 35),
+		})
+	}
+	for _, guid := range sortedKeys(suppliers) {
+		contact := contactsByGuid[guid]
+		file.MasterFiles.Suppliers = append(file.MasterFiles.Suppliers, saftSupplier{
+			RegistrationNumber: truncate(contact.VatNumber, 35),
+			Name:               truncate(nonEmpty(contact.Name, suppliers[guid], "Ukendt"), 70),
+			Address:            contactAddress(contact),
+			SupplierID:         truncate(guid, 35),
+		})
+	}
+
+	if err := missing.err(); err != nil {
+		return err
+	}
+
+	data, err := xml.MarshalIndent(file, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to build SAF-T file: %w", err)
+	}
+	data = append([]byte(xml.Header), data...)
+
+	schema, err := parseXSD(saftSchema)
+	if err != nil {
+		return err
+	}
+	if err := schema.validateXML(data); err != nil {
+		return fmt.Errorf("SAF-T file failed the structure check: %w", err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0644)
+}
+
+// saftHeader describes the company from the organization snapshot and the mapping's contact person.
+// Required company data that is missing is added to missing.
+func (b *Backup) saftHeader(year backup.FiscalYear, mapping *SAFTMapping, missing *missingSAFTData) saftHeader {
+	company := b.organizationSettings("company")
+	organization := b.organizationSettings("organization")
+
+	header := saftHeader{
+		AuditFileVersion:     "1.0",
+		AuditFileCountry:     "DK",
+		AuditFileDateCreated: time.Now().Format("2006-01-02"),
+		SoftwareCompanyName:  "dinero-backup",
+		SoftwareID:           "dinero-backup",
+		SoftwareVersion:      truncate(softwareVersion(), 18),
+		DefaultCurrencyCode:  "DKK",
+		SelectionCriteria: saftSelection{
+			SelectionStartDate: year.From.Format("2006-01-02"),
+			SelectionEndDate:   year.To.Format("2006-01-02"),
+		},
+		TaxAccountingBasis: "A",
+	}
+
+	header.Company.RegistrationNumber = truncate(nonEmpty(stringField(company, "VatNumber", "CvrNumber", "Cvr", "RegistrationNumber"), stringField(organization, "VatNumber")), 35)
+	header.Company.Name = truncate(nonEmpty(stringField(company, "Name", "CompanyName"), stringField(organization, "Name"), "Ukendt"), 70)
+	header.Company.Address = saftAddress{
+		StreetName: truncate(nonEmpty(stringField(company, "Street", "Address"), stringField(organization, "Street")), 70),
+		City:       truncate(nonEmpty(stringField(company, "City"), stringField(organization, "City")), 35),
+		PostalCode: truncate(nonEmpty(stringField(company, "ZipCode", "PostalCode"), stringField(organization, "ZipCode")), 18),
+		Country:    countryCode(nonEmpty(stringField(company, "CountryKey", "Country"), stringField(organization, "CountryKey"))),
+	}
+	header.Company.Contact = saftContact{
+		FirstName: truncate(mapping.Contact.FirstName, 35),
+		LastName:  truncate(mapping.Contact.LastName, 70),
+		Telephone: truncate(nonEmpty(mapping.Contact.Telephone, stringField(company, "Phone"), stringField(organization, "Phone")), 35),
+		Email:     truncate(nonEmpty(mapping.Contact.Email, stringField(company, "Email"), stringField(organization, "Email")), 70),
+	}
+
+	// The CVR number is both the company's registration and its tax registration in Denmark
+	header.Company.TaxRegistration = saftTaxRegistration{
+		TaxRegistrationNumber: header.Company.RegistrationNumber,
+		TaxAuthority:          "Skattestyrelsen",
+	}
+	if organization["IsVatRegistered"] == true {
+		header.Company.TaxRegistration.TaxType = "MOMS"
+	}
+
+	if header.Company.RegistrationNumber == "" {
+		missing.add("company CVR number (organization/company.json or organization/organization.json)")
+	}
+	if header.Company.Address.City == "" || header.Company.Address.PostalCode == "" {
+		missing.add("company city and postal code (organization/company.json or organization/organization.json)")
+	}
+	if header.Company.Contact.FirstName == "" || header.Company.Contact.LastName == "" {
+		missing.add("contact person's first and last name in the mapping")
+	}
+
+	return header
+}
+
+// saftLedger groups entries into journals by voucher type and transactions by voucher number.
+// Primo entries are left out, as they are reported as opening balances on the accounts.
+// Contacts on sales and purchase entries are added to customers and suppliers.
+// A transaction's system entry date is the first time the backup saw any of its entries; transactions
+// missing from the entry history are added to missing.
+func saftLedger(year backup.FiscalYear, entries []backup.Entry, firstSeen map[string]string, customers, suppliers map[string]string, missing *missingSAFTData) saftGeneralLedgerEntries {
+	byJournal := make(map[string]map[string][]backup.Entry)
+	for _, entry := range entries {
+		if isPrimo(entry) {
+			continue
+		}
+		journalID := otherJournalID
+		if entry.VoucherType != nil {
+			for _, journal := range journals {
+				if journal.VoucherType == *entry.VoucherType {
+					journalID = journal.ID
+				}
+			}
+		}
+		if byJournal[journalID] == nil {
+			byJournal[journalID] = make(map[string][]backup.Entry)
+		}
+		key := transactionKey(entry)
+		byJournal[journalID][key] = append(byJournal[journalID][key], entry)
+	}
+
+	ledger := saftGeneralLedgerEntries{}
+	var totalDebit, totalCredit backup.Money
+	var unseen []string
+
+	allJournals := append(journals[:len(journals):len(journals)], struct {
+		VoucherType string
+		ID          string
+		Type        string
+	}{ID: otherJournalID, Type: "O"})
+
+	for _, journal := range allJournals {
+		transactions := byJournal[journal.ID]
+		if len(transactions) == 0 {
+			continue
+		}
+		saftJ := saftJournal{JournalID: journal.ID, Description: journalDescription(journal.VoucherType), Type: journal.Type}
+
+		for _, key := range sortedTransactionKeys(transactions) {
+			group := transactions[key]
+			date := entryDate(group[0].Date)
+			transaction := saftTransaction{
+				TransactionID:   truncate(key, 70),
+				Period:          fiscalPeriod(year, date),
+				PeriodYear:      year.From.Year(),
+				TransactionDate: date,
+				Description:     truncate(nonEmpty(group[0].Description, key), 256),
+				SystemEntryDate: systemEntryDate(group, firstSeen),
+				GLPostingDate:   date,
+			}
+			if transaction.SystemEntryDate == "" {
+				unseen = append(unseen, key)
+			}
+
+			for i, entry := range group {
+				line := saftLine{
+					RecordID:    truncate(nonEmpty(entry.EntryGuid, fmt.Sprintf("%s-%d", key, i+1)), 70),
+					AccountID:   strconv.Itoa(entry.AccountNumber),
+					Description: truncate(nonEmpty(entry.Description, transaction.Description), 256),
+				}
+				if entry.ContactGuid != nil && *entry.ContactGuid != "" {
+					switch journal.ID {
+					case "SALG":
+						line.CustomerID = truncate(*entry.ContactGuid, 35)
+						customers[*entry.ContactGuid] = ""
+					case "KOEB":
+						line.SupplierID = truncate(*entry.ContactGuid, 35)
+						suppliers[*entry.ContactGuid] = ""
+					}
+				}
+				if entry.Amount >= 0 {
+					line.DebitAmount = &saftAmount{Amount: formatAmount(entry.Amount)}
+					totalDebit += entry.Amount
+				} else {
+					line.CreditAmount = &saftAmount{Amount: formatAmount(-entry.Amount)}
+					totalCredit -= entry.Amount
+				}
+				transaction.Lines = append(transaction.Lines, line)
+			}
+
+			saftJ.Transactions = append(saftJ.Transactions, transaction)
+			ledger.NumberOfEntries++
+		}
+		ledger.Journals = append(ledger.Journals, saftJ)
+	}
+
+	if len(unseen) > 0 {
+		examples := unseen
+		if len(examples) > 5 {
+			examples = examples[:5]
+		}
+		missing.add("entry history for %d transactions (e.g. %s); run a backup with --entries to record it", len(unseen), strings.Join(examples, ", "))
+	}
+
+	ledger.TotalDebit = formatAmount(totalDebit)
+	ledger.TotalCredit = formatAmount(totalCredit)
+	return ledger
+}
+
+// systemEntryDate returns the date any of a transaction's entries was first seen, or "" if none were
+func systemEntryDate(group []backup.Entry, firstSeen map[string]string) string {
+	earliest := ""
+	for _, entry := range group {
+		if seenAt := firstSeen[entry.EntryGuid]; len(seenAt) >= 10 && (earliest == "" || seenAt < earliest) {
+			earliest = seenAt
+		}
+	}
+	if earliest == "" {
+		return ""
+	}
+	return earliest[:10]
+}
+
+func journalDescription(voucherType string) string {
+	if voucherType == "" {
+		return "Øvrige posteringer"
+	}
+	return backup.VoucherTypeName(&voucherType, "")
+}
+
+// transactionKey identifies the voucher an entry belongs to. Entries without a voucher number
+// are grouped by date and description, so the entries booked together form one balanced
+// transaction; the description is hashed to keep the key short.
+func transactionKey(entry backup.Entry) string {
+	if entry.VoucherNumber != nil {
+		voucherType := ""
+		if entry.VoucherType != nil {
+			voucherType = *entry.VoucherType
+		}
+		return fmt.Sprintf("%s-%d", voucherType, *entry.VoucherNumber)
+	}
+	sum := sha256.Sum256([]byte(entry.Description))
+	return fmt.Sprintf("entry-%s-%s", entryDate(entry.Date), hex.EncodeToString(sum[:4]))
+}
+
+// sortedTransactionKeys orders transactions by date, then by key
+func sortedTransactionKeys(transactions map[string][]backup.Entry) []string {
+	keys := make([]string, 0, len(transactions))
+	for key := range transactions {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		a, b := entryDate(transactions[keys[i]][0].Date), entryDate(transactions[keys[j]][0].Date)
+		if a != b {
+			return a < b
+		}
+		return keys[i] < keys[j]
+	})
+	return keys
+}
+
+// fiscalPeriod returns the month of the accounting year a date falls in, starting at 1
+func fiscalPeriod(year backup.FiscalYear, date string) int {
+	t, err := time.Parse("2006-01-02", date)
+	if err != nil {
+		return 1
+	}
+	months := (t.Year()-year.From.Year())*12 + int(t.Month()) - int(year.From.Month()) + 1
+	if months < 1 {
+		return 1
+	}
+	return months
+}
+
+func containsDate(year backup.FiscalYear, date string) bool {
+	if len(date) < 10 {
+		return false
+	}
+	t, err := time.Parse("2006-01-02", date[:10])
+	return err == nil && year.Contains(t)
+}
+
+// debitCredit splits a balance into a debit or a credit amount; exactly one is set
+func debitCredit(balance backup.Money) (debit, credit string) {
+	if balance >= 0 {
+		return formatAmount(balance), ""
+	}
+	return "", formatAmount(-balance)
+}
+
+// formatAmount formats an amount with a decimal point and two decimals
+func formatAmount(amount backup.Money) string {
+	return amount.String()
+}
+
+func contactAddress(contact Contact) *saftAddress {
+	address := &saftAddress{
+		StreetName: truncate(contact.Street, 70),
+		City:       truncate(contact.City, 35),
+		PostalCode: truncate(contact.ZipCode, 18),
+		Country:    countryCode(contact.CountryKey),
+	}
+	if *address == (saftAddress{}) {
+		return nil
+	}
+	return address
+}
+
+// countryCode returns a two-letter country code, or "" if the value isn't one
+func countryCode(value string) string {
+	if len(value) == 2 {
+		return strings.ToUpper(value)
+	}
+	return ""
+}
+
+func softwareVersion() string {
+	if info, ok := debug.ReadBuildInfo(); ok && info.Main.Version != "" && info.Main.Version != "(devel)" {
+		return info.Main.Version
+	}
+	return "dev"
+}
+
+// truncate shortens s to at most n characters, as SAF-T limits text lengths
+func truncate(s string, n int) string {
+	runes := []rune(s)
+	if len(runes) <= n {
+		return s
+	}
+	return string(runes[:n])
+}
+
+// nonEmpty returns the first non-empty value
+func nonEmpty(values ...string) string {
+	for _, value := range values {
+		if value != "" {
+			return value
+		}
+	}
+	return ""
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
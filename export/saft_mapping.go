@@ -0,0 +1,62 @@
+package export
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// SAFTMappingFile is the default name of the SAF-T mapping in the backup directory
+const SAFTMappingFile = "saft_mapping.json"
+
+// SAFTMapping holds the SAF-T data a Dinero backup doesn't contain: the company's contact person
+// and the grouping of every account into the standard chart of accounts
+type SAFTMapping struct {
+	Contact SAFTContact `json:"Contact"`
+	// Accounts maps account numbers to their grouping
+	Accounts map[string]SAFTGrouping `json:"Accounts"`
+}
+
+// SAFTContact is the company's contact person. Telephone and email default to the organization's.
+type SAFTContact struct {
+	FirstName string `json:"FirstName"`
+	LastName  string `json:"LastName"`
+	Telephone string `json:"Telephone"`
+	Email     string `json:"Email"`
+}
+
+// SAFTGrouping places an account in the standard chart of accounts
+type SAFTGrouping struct {
+	GroupingCategory string `json:"GroupingCategory"`
+	GroupingCode     string `json:"GroupingCode"`
+}
+
+// LoadSAFTMapping reads a SAF-T mapping file
+func LoadSAFTMapping(path string) (*SAFTMapping, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("no SAF-T mapping in %s (see the README for its format): %w", path, err)
+	}
+
+	var mapping SAFTMapping
+	if err := json.Unmarshal(data, &mapping); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+	return &mapping, nil
+}
+
+// missingSAFTData collects the data a SAF-T file requires but the backup and mapping don't provide,
+// so the export can fail with every problem at once instead of writing an incomplete file
+type missingSAFTData []string
+
+func (m *missingSAFTData) add(format string, args ...any) {
+	*m = append(*m, fmt.Sprintf(format, args...))
+}
+
+func (m missingSAFTData) err() error {
+	if len(m) == 0 {
+		return nil
+	}
+	return fmt.Errorf("SAF-T file is missing required data:\n  %s", strings.Join(m, "\n  "))
+}
@@ -0,0 +1,198 @@
+package export
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/text/encoding/charmap"
+
+	"github.com/rostved/dinero-backup/backup"
+)
+
+// sieSeries are the SIE voucher series used for Dinero voucher types
+var sieSeries = map[string]string{
+	"Sales":     "S",
+	"Purchases": "K",
+	"manuel":    "F",
+}
+
+// sieOtherSeries is used for entries with any other voucher type
+const sieOtherSeries = "O"
+
+// SIE writes a SIE 4 file for an accounting year: the chart of accounts (#KONTO), opening and
+// closing balances of balance sheet accounts (#IB/#UB), results of income statement accounts
+// (#RES) and every voucher (#VER) with its entries (#TRANS). The file is encoded in CP437 (PC8),
+// as the format requires.
+func SIE(b *Backup, year backup.FiscalYear, path string) error {
+	entries, err := b.Entries(year)
+	if err != nil {
+		return err
+	}
+
+	accounts := b.Accounts(entries)
+	balanceAccounts := b.balanceAccounts(accounts)
+	opening, closing := accountBalances(entries)
+	company := b.organizationSettings("company")
+	organization := b.organizationSettings("organization")
+
+	var buf bytes.Buffer
+	w := &sieWriter{buf: &buf}
+
+	w.line("#FLAGGA", "0")
+	w.line("#FORMAT", "PC8")
+	w.line("#SIETYP", "4")
+	w.line("#PROGRAM", sieString("dinero-backup"), sieString(softwareVersion()))
+	w.line("#GEN", time.Now().Format("20060102"))
+	w.line("#FNAMN", sieString(nonEmpty(stringField(company, "Name", "CompanyName"), stringField(organization, "Name"))))
+	if number := nonEmpty(stringField(company, "VatNumber", "CvrNumber", "Cvr", "RegistrationNumber"), stringField(organization, "VatNumber")); number != "" {
+		w.line("#ORGNR", number)
+	}
+	w.line("#RAR", "0", year.From.Format("20060102"), year.To.Format("20060102"))
+	for i, other := range b.Years {
+		if other.Name == year.Name && i > 0 {
+			previous := b.Years[i-1]
+			w.line("#RAR", "-1", previous.From.Format("20060102"), previous.To.Format("20060102"))
+		}
+	}
+	w.line("#VALUTA", "DKK")
+
+	for _, account := range accounts {
+		w.line("#KONTO", strconv.Itoa(account.Number), sieString(account.Name))
+	}
+
+	for _, account := range accounts {
+		if _, used := closing[account.Number]; !used {
+			continue
+		}
+		number := strconv.Itoa(account.Number)
+		if balanceAccounts[account.Number] {
+			w.line("#IB", "0", number, opening[account.Number].String())
+			w.line("#UB", "0", number, closing[account.Number].String())
+		} else {
+			w.line("#RES", "0", number, closing[account.Number].String())
+		}
+	}
+
+	for _, voucher := range sieVouchers(entries) {
+		first := voucher.Entries[0]
+		date := sieDate(first.Date)
+		w.line("#VER", sieString(voucher.Series), sieString(voucher.Number), date, sieString(first.Description))
+		w.raw("{")
+		for _, entry := range voucher.Entries {
+			w.line("   #TRANS", strconv.Itoa(entry.AccountNumber), "{}", entry.Amount.String(), sieDate(entry.Date), sieString(entry.Description))
+		}
+		w.raw("}")
+	}
+
+	encoded := encodeCP437(buf.String())
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(path, encoded, 0644)
+}
+
+// sieVoucher is a voucher (verifikation) with its entries
+type sieVoucher struct {
+	Series  string
+	Number  string
+	Entries []backup.Entry
+}
+
+// sieVouchers groups entries by voucher type and number, ordered by date. Primo entries are
+// reported as opening balances and left out. Entries without a voucher number get a voucher each.
+func sieVouchers(entries []backup.Entry) []sieVoucher {
+	byKey := make(map[string]*sieVoucher)
+	var vouchers []*sieVoucher
+
+	for _, entry := range entries {
+		if isPrimo(entry) {
+			continue
+		}
+		series := sieOtherSeries
+		if entry.VoucherType != nil {
+			if s, ok := sieSeries[*entry.VoucherType]; ok {
+				series = s
+			}
+		}
+		number := ""
+		if entry.VoucherNumber != nil {
+			number = strconv.Itoa(*entry.VoucherNumber)
+		}
+
+		key := transactionKey(entry)
+		voucher, ok := byKey[key]
+		if !ok {
+			voucher = &sieVoucher{Series: series, Number: number}
+			byKey[key] = voucher
+			vouchers = append(vouchers, voucher)
+		}
+		voucher.Entries = append(voucher.Entries, entry)
+	}
+
+	sort.SliceStable(vouchers, func(i, j int) bool {
+		return vouchers[i].Entries[0].Date < vouchers[j].Entries[0].Date
+	})
+
+	result := make([]sieVoucher, len(vouchers))
+	for i, voucher := range vouchers {
+		result[i] = *voucher
+	}
+	return result
+}
+
+type sieWriter struct {
+	buf *bytes.Buffer
+}
+
+func (w *sieWriter) line(label string, fields ...string) {
+	w.raw(label + " " + strings.Join(fields, " "))
+}
+
+func (w *sieWriter) raw(s string) {
+	w.buf.WriteString(s)
+	w.buf.WriteString("\r\n")
+}
+
+// cp437Substitutes replaces characters CP437 lacks with the closest character it has;
+// Danish ø and Ø are written as ö and Ö, as is customary in SIE files
+var cp437Substitutes = strings.NewReplacer(
+	"ø", "ö", "Ø", "Ö",
+	"–", "-", "—", "-",
+	"‘", "'", "’", "'", "“", "'", "”", "'",
+)
+
+// encodeCP437 encodes text in code page 437, replacing characters it can't represent with '?'
+func encodeCP437(s string) []byte {
+	s = cp437Substitutes.Replace(s)
+	encoded := make([]byte, 0, len(s))
+	for _, r := range s {
+		if b, ok := charmap.CodePage437.EncodeRune(r); ok {
+			encoded = append(encoded, b)
+		} else {
+			encoded = append(encoded, '?')
+		}
+	}
+	return encoded
+}
+
+// sieEscaper works in a single pass, so the backslashes it adds before quotes aren't escaped again
+var sieEscaper = strings.NewReplacer("\r\n", " ", "\n", " ", "\r", " ", `\`, `\\`, `"`, `\"`)
+
+// sieString quotes a SIE field, escaping backslashes and quotes and replacing line breaks
+func sieString(s string) string {
+	return `"` + sieEscaper.Replace(s) + `"`
+}
+
+// sieDate formats an entry date (YYYY-MM-DD) as YYYYMMDD
+func sieDate(date string) string {
+	if len(date) < 10 {
+		return date
+	}
+	return strings.ReplaceAll(date[:10], "-", "")
+}
@@ -0,0 +1,556 @@
+package export
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	_ "modernc.org/sqlite"
+
+	"github.com/rostved/dinero-backup/backup"
+)
+
+// sqliteSchemaVersion is stored in PRAGMA user_version; bump it when the schema changes
+const sqliteSchemaVersion = 1
+
+// sqliteSchema creates the tables. Amounts are stored as integer øre, so sums are exact.
+// Contacts referenced by entries or invoices but missing from the backup are added as
+// placeholders with backed_up = 0, so the foreign keys always hold.
+const sqliteSchema = `
+CREATE TABLE accounting_years (
+	name      TEXT PRIMARY KEY,
+	file_name TEXT NOT NULL,
+	from_date TEXT NOT NULL,
+	to_date   TEXT NOT NULL
+);
+
+CREATE TABLE accounts (
+	number   INTEGER PRIMARY KEY,
+	name     TEXT,
+	category TEXT,
+	vat_code TEXT,
+	deposit  INTEGER NOT NULL DEFAULT 0
+);
+
+CREATE TABLE contacts (
+	guid               TEXT PRIMARY KEY,
+	name               TEXT,
+	is_person          INTEGER,
+	external_reference TEXT,
+	street             TEXT,
+	zip_code           TEXT,
+	city               TEXT,
+	country_key        TEXT,
+	phone              TEXT,
+	email              TEXT,
+	vat_number         TEXT,
+	ean_number         TEXT,
+	created_at         TEXT,
+	updated_at         TEXT,
+	deleted_at         TEXT,
+	backed_up          INTEGER NOT NULL DEFAULT 1
+);
+
+CREATE TABLE vouchers (
+	id           TEXT PRIMARY KEY,
+	year         TEXT NOT NULL REFERENCES accounting_years(name),
+	voucher_type TEXT NOT NULL,
+	number       INTEGER NOT NULL,
+	date         TEXT
+);
+
+-- Primo entries may have no GUID
+CREATE TABLE entries (
+	id             INTEGER PRIMARY KEY,
+	guid           TEXT UNIQUE,
+	year           TEXT NOT NULL REFERENCES accounting_years(name),
+	account_number INTEGER NOT NULL REFERENCES accounts(number),
+	contact_guid   TEXT REFERENCES contacts(guid),
+	voucher_id     TEXT REFERENCES vouchers(id),
+	voucher_type   TEXT,
+	voucher_number INTEGER,
+	date           TEXT NOT NULL,
+	description    TEXT,
+	vat_type       TEXT,
+	vat_code       TEXT,
+	amount_ore     INTEGER NOT NULL,
+	type           TEXT,
+	tombstoned_at  TEXT
+);
+CREATE INDEX entries_year ON entries(year);
+CREATE INDEX entries_account ON entries(account_number);
+CREATE INDEX entries_contact ON entries(contact_guid);
+CREATE INDEX entries_voucher ON entries(voucher_id);
+
+CREATE TABLE invoices (
+	guid               TEXT PRIMARY KEY,
+	number             INTEGER,
+	status             TEXT,
+	date               TEXT,
+	payment_date       TEXT,
+	contact_guid       TEXT REFERENCES contacts(guid),
+	contact_name       TEXT,
+	description        TEXT,
+	external_reference TEXT,
+	currency           TEXT,
+	total_excl_vat_ore INTEGER,
+	total_incl_vat_ore INTEGER,
+	created_at         TEXT,
+	updated_at         TEXT
+);
+CREATE INDEX invoices_contact ON invoices(contact_guid);
+
+CREATE TABLE files (
+	guid          TEXT PRIMARY KEY,
+	voucher_id    TEXT REFERENCES vouchers(id),
+	original_name TEXT,
+	status        TEXT,
+	uploaded_at   TEXT,
+	size          INTEGER,
+	sha256        TEXT,
+	stored_name   TEXT,
+	voucher_path  TEXT
+);
+CREATE INDEX files_voucher ON files(voucher_id);
+
+-- sources records a fingerprint of the backup files each table was loaded from
+CREATE TABLE sources (
+	name        TEXT PRIMARY KEY,
+	fingerprint TEXT NOT NULL,
+	loaded_at   TEXT NOT NULL
+);
+`
+
+// sqliteSource is a part of the backup that is loaded into the database as a unit
+type sqliteSource struct {
+	Name  string
+	Files []string
+	Load  func(tx *sql.Tx) (int, error)
+}
+
+// SQLite loads the backup into a SQLite database with a table per resource and foreign keys
+// between them. Running it again on an existing database only reloads the parts of the backup
+// whose files changed since the last run.
+func SQLite(b *Backup, path string) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	// Pragmas apply per connection
+	db.SetMaxOpenConns(1)
+
+	if _, err := db.Exec("PRAGMA foreign_keys = ON"); err != nil {
+		return err
+	}
+	if err := migrateSQLite(db); err != nil {
+		return err
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// Sources are reloaded by deleting and inserting their rows; references are checked on commit
+	if _, err := tx.Exec("PRAGMA defer_foreign_keys = ON"); err != nil {
+		return err
+	}
+
+	now := time.Now().UTC().Format(time.RFC3339)
+	for _, source := range b.sqliteSources() {
+		fingerprint, err := fileFingerprint(source.Files)
+		if err != nil {
+			return err
+		}
+
+		var previous string
+		err = tx.QueryRow("SELECT fingerprint FROM sources WHERE name = ?", source.Name).Scan(&previous)
+		if err != nil && err != sql.ErrNoRows {
+			return err
+		}
+		if previous == fingerprint {
+			continue
+		}
+
+		rows, err := source.Load(tx)
+		if err != nil {
+			return fmt.Errorf("failed to load %s: %w", source.Name, err)
+		}
+		if _, err := tx.Exec(`INSERT INTO sources (name, fingerprint, loaded_at) VALUES (?, ?, ?)
+			ON CONFLICT (name) DO UPDATE SET fingerprint = excluded.fingerprint, loaded_at = excluded.loaded_at`,
+			source.Name, fingerprint, now); err != nil {
+			return err
+		}
+		log.Printf("Loaded %s (%d rows).", source.Name, rows)
+	}
+
+	if err := b.loadVouchers(tx); err != nil {
+		return fmt.Errorf("failed to load vouchers: %w", err)
+	}
+	if err := addPlaceholderContacts(tx); err != nil {
+		return err
+	}
+	if err := checkForeignKeys(tx); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func migrateSQLite(db *sql.DB) error {
+	var version int
+	if err := db.QueryRow("PRAGMA user_version").Scan(&version); err != nil {
+		return err
+	}
+	switch {
+	case version == sqliteSchemaVersion:
+		return nil
+	case version > sqliteSchemaVersion:
+		return fmt.Errorf("database schema version %d is newer than this tool supports (%d)", version, sqliteSchemaVersion)
+	case version != 0:
+		return fmt.Errorf("database schema version %d is outdated; delete the database to export it again", version)
+	}
+
+	if _, err := db.Exec(sqliteSchema); err != nil {
+		return fmt.Errorf("failed to create database schema: %w", err)
+	}
+	_, err := db.Exec(fmt.Sprintf("PRAGMA user_version = %d", sqliteSchemaVersion))
+	return err
+}
+
+// sqliteSources lists the sources in dependency order, with the files each is loaded from
+func (b *Backup) sqliteSources() []sqliteSource {
+	yearsFile := filepath.Join(b.Dir, "organization", "accountingyears.json")
+	var entryFiles []string
+	for _, year := range b.Years {
+		entryFiles = append(entryFiles, backup.EntriesPath(b.Dir, year))
+	}
+
+	sources := []sqliteSource{
+		{Name: "accounting_years", Files: []string{yearsFile}, Load: b.loadAccountingYears},
+		// Accounts only found on entries are included, so entries are a source of accounts too
+		{Name: "accounts", Files: append([]string{
+			filepath.Join(b.Dir, "organization", "accounts_entry.json"),
+			filepath.Join(b.Dir, "organization", "accounts_deposit.json"),
+		}, entryFiles...), Load: b.loadAccounts},
+		{Name: "contacts", Files: []string{filepath.Join(b.Dir, "contacts", "contacts.json")}, Load: b.loadContacts},
+	}
+
+	for _, year := range b.Years {
+		sources = append(sources, sqliteSource{
+			Name:  "entries_" + year.FileName(),
+			Files: []string{backup.EntriesPath(b.Dir, year)},
+			Load: func(tx *sql.Tx) (int, error) {
+				return b.loadEntries(tx, year)
+			},
+		})
+	}
+
+	invoiceFiles, _ := filepath.Glob(filepath.Join(b.Dir, "invoices", "invoices_*.json"))
+	deletedFiles, _ := filepath.Glob(filepath.Join(b.Dir, "deleted", "invoices", "deleted_invoices_*.json"))
+	sources = append(sources,
+		sqliteSource{Name: "invoices", Files: append(invoiceFiles, deletedFiles...), Load: b.loadInvoices},
+		// Voucher IDs of files depend on the accounting years
+		sqliteSource{Name: "files", Files: []string{filepath.Join(b.Dir, "files", "index.json"), yearsFile}, Load: b.loadFiles},
+	)
+	return sources
+}
+
+// loadAccountingYears replaces the accounting years. Entries of years no longer in the backup are
+// removed with their source rows, as they would otherwise reference a missing year.
+func (b *Backup) loadAccountingYears(tx *sql.Tx) (int, error) {
+	names := make([]any, 0, len(b.Years))
+	sources := make([]any, 0, len(b.Years))
+	for _, year := range b.Years {
+		names = append(names, year.Name)
+		sources = append(sources, "entries_"+year.FileName())
+	}
+	placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(b.Years)), ", ")
+
+	if _, err := tx.Exec("DELETE FROM entries WHERE year NOT IN ("+placeholders+")", names...); err != nil {
+		return 0, err
+	}
+	if _, err := tx.Exec(`DELETE FROM sources WHERE name LIKE 'entries\_%' ESCAPE '\' AND name NOT IN (`+placeholders+")", sources...); err != nil {
+		return 0, err
+	}
+	if _, err := tx.Exec("DELETE FROM accounting_years"); err != nil {
+		return 0, err
+	}
+	for _, year := range b.Years {
+		if _, err := tx.Exec("INSERT INTO accounting_years (name, file_name, from_date, to_date) VALUES (?, ?, ?, ?)",
+			year.Name, year.FileName(), year.From.Format("2006-01-02"), year.To.Format("2006-01-02")); err != nil {
+			return 0, err
+		}
+	}
+	return len(b.Years), nil
+}
+
+func (b *Backup) loadAccounts(tx *sql.Tx) (int, error) {
+	var entries []backup.Entry
+	for _, year := range b.Years {
+		yearEntries, err := backup.LoadAllEntries(b.Dir, year)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return 0, err
+		}
+		entries = append(entries, yearEntries...)
+	}
+
+	if _, err := tx.Exec("DELETE FROM accounts"); err != nil {
+		return 0, err
+	}
+	accounts := b.Accounts(entries)
+	for _, account := range accounts {
+		if _, err := tx.Exec("INSERT INTO accounts (number, name, category, vat_code, deposit) VALUES (?, ?, ?, ?, ?)",
+			account.Number, account.Name, nullString(account.Category), nullString(account.VatCode), account.Deposit); err != nil {
+			return 0, err
+		}
+	}
+	return len(accounts), nil
+}
+
+func (b *Backup) loadContacts(tx *sql.Tx) (int, error) {
+	contacts, err := b.allContacts()
+	if err != nil {
+		return 0, err
+	}
+
+	if _, err := tx.Exec("DELETE FROM contacts"); err != nil {
+		return 0, err
+	}
+	for _, c := range contacts {
+		if _, err := tx.Exec(`INSERT INTO contacts (guid, name, is_person, external_reference, street, zip_code, city,
+			country_key, phone, email, vat_number, ean_number, created_at, updated_at, deleted_at)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+			c.ContactGuid, c.Name, c.IsPerson, nullString(c.ExternalReference), nullString(c.Street), nullString(c.ZipCode),
+			nullString(c.City), nullString(c.CountryKey), nullString(c.Phone), nullString(c.Email), nullString(c.VatNumber),
+			nullString(c.EanNumber), nullString(c.CreatedAt), nullString(c.UpdatedAt), nullString(c.DeletedAt)); err != nil {
+			return 0, err
+		}
+	}
+	return len(contacts), nil
+}
+
+func (b *Backup) loadEntries(tx *sql.Tx, year backup.FiscalYear) (int, error) {
+	entries, err := backup.LoadAllEntries(b.Dir, year)
+	if err != nil && !os.IsNotExist(err) {
+		return 0, err
+	}
+
+	if _, err := tx.Exec("DELETE FROM entries WHERE year = ?", year.Name); err != nil {
+		return 0, err
+	}
+	for _, e := range entries {
+		var voucherID, voucherType any
+		if e.VoucherType != nil {
+			voucherType = *e.VoucherType
+			if e.VoucherNumber != nil {
+				voucherID = voucherKey(year, *e.VoucherType, *e.VoucherNumber)
+			}
+		}
+		var voucherNumber, contactGuid any
+		if e.VoucherNumber != nil {
+			voucherNumber = *e.VoucherNumber
+		}
+		if e.ContactGuid != nil && *e.ContactGuid != "" {
+			contactGuid = *e.ContactGuid
+		}
+
+		if _, err := tx.Exec(`INSERT INTO entries (guid, year, account_number, contact_guid, voucher_id, voucher_type,
+			voucher_number, date, description, vat_type, vat_code, amount_ore, type, tombstoned_at)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+			nullString(e.EntryGuid), year.Name, e.AccountNumber, contactGuid, voucherID, voucherType, voucherNumber, e.Date,
+			e.Description, nullString(e.VatType), nullString(e.VatCode), int64(e.Amount), nullString(e.Type),
+			nullString(e.TombstonedAt)); err != nil {
+			return 0, fmt.Errorf("entry %s: %w", e.EntryGuid, err)
+		}
+	}
+	return len(entries), nil
+}
+
+func (b *Backup) loadInvoices(tx *sql.Tx) (int, error) {
+	invoices, err := b.Invoices()
+	if err != nil {
+		return 0, err
+	}
+
+	if _, err := tx.Exec("DELETE FROM invoices"); err != nil {
+		return 0, err
+	}
+	for _, i := range invoices {
+		var number any
+		if i.Number != 0 {
+			number = i.Number
+		}
+		if _, err := tx.Exec(`INSERT INTO invoices (guid, number, status, date, payment_date, contact_guid, contact_name,
+			description, external_reference, currency, total_excl_vat_ore, total_incl_vat_ore, created_at, updated_at)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+			i.Guid, number, i.Status, nullString(i.Date), nullString(i.PaymentDate), nullString(i.ContactGuid),
+			nullString(i.ContactName), nullString(i.Description), nullString(i.ExternalReference), nullString(i.Currency),
+			int64(i.TotalExclVat), int64(i.TotalInclVat), nullString(i.CreatedAt), nullString(i.UpdatedAt)); err != nil {
+			return 0, err
+		}
+	}
+	return len(invoices), nil
+}
+
+func (b *Backup) loadFiles(tx *sql.Tx) (int, error) {
+	files, err := b.Files()
+	if err != nil {
+		return 0, err
+	}
+
+	if _, err := tx.Exec("DELETE FROM files"); err != nil {
+		return 0, err
+	}
+	for _, f := range files {
+		var voucherID any
+		if year, ok := b.fileVoucherYear(f); ok {
+			voucherID = voucherKey(year, f.Voucher.VoucherType, f.Voucher.VoucherNumber)
+		}
+		if _, err := tx.Exec(`INSERT INTO files (guid, voucher_id, original_name, status, uploaded_at, size, sha256,
+			stored_name, voucher_path) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+			f.FileGuid, voucherID, f.OriginalName, f.Status, nullString(f.UploadedAt), f.Size, nullString(f.SHA256),
+			nullString(f.StoredName), nullString(f.VoucherPath)); err != nil {
+			return 0, err
+		}
+	}
+	return len(files), nil
+}
+
+// loadVouchers rebuilds the vouchers from the entries in the database, and adds the vouchers
+// files are attached to that have no entries in the backup. It runs on every export, as both
+// entries and files refer to vouchers.
+func (b *Backup) loadVouchers(tx *sql.Tx) error {
+	if _, err := tx.Exec("DELETE FROM vouchers"); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`INSERT INTO vouchers (id, year, voucher_type, number, date)
+		SELECT voucher_id, year, voucher_type, voucher_number, MIN(date) FROM entries
+		WHERE voucher_id IS NOT NULL GROUP BY voucher_id`); err != nil {
+		return err
+	}
+
+	files, err := b.Files()
+	if err != nil {
+		return err
+	}
+	for _, f := range files {
+		year, ok := b.fileVoucherYear(f)
+		if !ok {
+			continue
+		}
+		if _, err := tx.Exec(`INSERT INTO vouchers (id, year, voucher_type, number, date) VALUES (?, ?, ?, ?, ?)
+			ON CONFLICT (id) DO NOTHING`,
+			voucherKey(year, f.Voucher.VoucherType, f.Voucher.VoucherNumber), year.Name, f.Voucher.VoucherType,
+			f.Voucher.VoucherNumber, nullString(f.Voucher.VoucherDate)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// fileVoucherYear returns the accounting year of the voucher a file is attached to
+func (b *Backup) fileVoucherYear(f backup.FileIndexEntry) (backup.FiscalYear, bool) {
+	if f.Voucher == nil {
+		return backup.FiscalYear{}, false
+	}
+	year, err := b.Year(f.Voucher.Year)
+	return year, err == nil
+}
+
+// addPlaceholderContacts adds contacts referenced by entries or invoices but missing from the
+// backup, and removes placeholders that are no longer referenced
+func addPlaceholderContacts(tx *sql.Tx) error {
+	if _, err := tx.Exec(`INSERT INTO contacts (guid, backed_up)
+		SELECT contact_guid, 0 FROM entries WHERE contact_guid IS NOT NULL
+		UNION SELECT contact_guid, 0 FROM invoices WHERE contact_guid IS NOT NULL
+		ON CONFLICT (guid) DO NOTHING`); err != nil {
+		return err
+	}
+	_, err := tx.Exec(`DELETE FROM contacts WHERE backed_up = 0
+		AND guid NOT IN (SELECT contact_guid FROM entries WHERE contact_guid IS NOT NULL)
+		AND guid NOT IN (SELECT contact_guid FROM invoices WHERE contact_guid IS NOT NULL)`)
+	return err
+}
+
+// checkForeignKeys reports broken references before commit, which would otherwise only
+// fail with a generic constraint error
+func checkForeignKeys(tx *sql.Tx) error {
+	rows, err := tx.Query("PRAGMA foreign_key_check")
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	var problems []string
+	for rows.Next() {
+		var table, parent string
+		var rowid sql.NullInt64
+		var fkid int
+		if err := rows.Scan(&table, &rowid, &parent, &fkid); err != nil {
+			return err
+		}
+		problems = append(problems, fmt.Sprintf("%s row %d references a missing %s", table, rowid.Int64, parent))
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	if len(problems) > 0 {
+		if len(problems) > maxValidationErrors {
+			problems = problems[:maxValidationErrors]
+		}
+		return fmt.Errorf("broken references in database:\n  %s", strings.Join(problems, "\n  "))
+	}
+	return nil
+}
+
+// voucherKey identifies a voucher: voucher numbers restart every accounting year
+func voucherKey(year backup.FiscalYear, voucherType string, number int) string {
+	return fmt.Sprintf("%s/%s-%d", year.FileName(), voucherType, number)
+}
+
+// fileFingerprint hashes the names and contents of files, so changes to any of them are detected.
+// Missing files are part of the fingerprint too.
+func fileFingerprint(paths []string) (string, error) {
+	sorted := append([]string(nil), paths...)
+	sort.Strings(sorted)
+
+	h := sha256.New()
+	for _, path := range sorted {
+		data, err := os.ReadFile(path)
+		if os.IsNotExist(err) {
+			fmt.Fprintf(h, "%s missing\n", filepath.Base(path))
+			continue
+		}
+		if err != nil {
+			return "", err
+		}
+		sum := sha256.Sum256(data)
+		fmt.Fprintf(h, "%s %x\n", filepath.Base(path), sum)
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// nullString stores empty strings as NULL
+func nullString(s string) any {
+	if s == "" {
+		return nil
+	}
+	return s
+}
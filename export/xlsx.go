@@ -0,0 +1,156 @@
+package export
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"sort"
+
+	"github.com/rostved/dinero-backup/backup"
+)
+
+// XLSX writes a workbook with a sheet of entries per backed up accounting year, and sheets for
+// contacts and invoices. Amounts, dates and running balances are written as numbers and dates,
+// so the sheets can be summed and filtered in Excel without conversion.
+func XLSX(b *Backup, path string) error {
+	var sheets []*xlsxSheet
+
+	for _, year := range b.Years {
+		entries, err := backup.LoadEntries(b.Dir, year)
+		if os.IsNotExist(err) {
+			log.Printf("No entries backed up for year %s, skipping.", year.Name)
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read entries for year %s: %w", year.Name, err)
+		}
+		sheets = append(sheets, entriesSheet(year, entries))
+	}
+	if len(sheets) == 0 {
+		return fmt.Errorf("no entries backed up in %s", b.Dir)
+	}
+
+	contacts, err := b.Contacts()
+	if err != nil {
+		return err
+	}
+	sheets = append(sheets, contactsSheet(contacts))
+
+	invoices, err := b.Invoices()
+	if err != nil {
+		return err
+	}
+	sheets = append(sheets, invoicesSheet(invoices))
+
+	return writeXLSX(path, sheets)
+}
+
+// entriesSheet lists the entries of a year like the CSV export: sorted by account and date,
+// with the running balance per account
+func entriesSheet(year backup.FiscalYear, entries []backup.Entry) *xlsxSheet {
+	sorted := append([]backup.Entry(nil), entries...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		if sorted[i].AccountNumber != sorted[j].AccountNumber {
+			return sorted[i].AccountNumber < sorted[j].AccountNumber
+		}
+		return sorted[i].Date < sorted[j].Date
+	})
+
+	sheet := &xlsxSheet{
+		Name: xlsxSheetName("Posteringer " + year.FileName()),
+		Columns: []xlsxColumn{
+			{"Konto", 8}, {"Kontonavn", 28}, {"Dato", 12}, {"Bilag", 8}, {"Bilagstype", 14},
+			{"Tekst", 40}, {"Momstype", 12}, {"Beløb", 14}, {"Saldo", 14},
+		},
+	}
+
+	balances := make(map[int]backup.Money)
+	for _, entry := range sorted {
+		balances[entry.AccountNumber] += entry.Amount
+
+		voucher := textCell("")
+		if entry.VoucherNumber != nil {
+			voucher = intCell(*entry.VoucherNumber)
+		}
+
+		sheet.Rows = append(sheet.Rows, []xlsxCell{
+			intCell(entry.AccountNumber),
+			textCell(entry.AccountName),
+			dateCell(entry.Date),
+			voucher,
+			textCell(backup.VoucherTypeName(entry.VoucherType, entry.Type)),
+			textCell(entry.Description),
+			textCell(entry.VatType),
+			amountCell(entry.Amount),
+			amountCell(balances[entry.AccountNumber]),
+		})
+	}
+	return sheet
+}
+
+func contactsSheet(contacts []Contact) *xlsxSheet {
+	sorted := append([]Contact(nil), contacts...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].Name < sorted[j].Name
+	})
+
+	sheet := &xlsxSheet{
+		Name: "Kontakter",
+		Columns: []xlsxColumn{
+			{"Navn", 32}, {"Type", 10}, {"CVR/VAT", 14}, {"EAN", 16}, {"Adresse", 30}, {"Postnr", 8},
+			{"By", 20}, {"Land", 8}, {"Telefon", 14}, {"Email", 30}, {"Ekstern reference", 18}, {"Kontakt-ID", 38},
+		},
+	}
+	for _, contact := range sorted {
+		kind := "Firma"
+		if contact.IsPerson {
+			kind = "Person"
+		}
+		sheet.Rows = append(sheet.Rows, []xlsxCell{
+			textCell(contact.Name),
+			textCell(kind),
+			textCell(contact.VatNumber),
+			textCell(contact.EanNumber),
+			textCell(contact.Street),
+			textCell(contact.ZipCode),
+			textCell(contact.City),
+			textCell(contact.CountryKey),
+			textCell(contact.Phone),
+			textCell(contact.Email),
+			textCell(contact.ExternalReference),
+			textCell(contact.ContactGuid),
+		})
+	}
+	return sheet
+}
+
+func invoicesSheet(invoices []Invoice) *xlsxSheet {
+	sheet := &xlsxSheet{
+		Name: "Fakturaer",
+		Columns: []xlsxColumn{
+			{"Nummer", 9}, {"Dato", 12}, {"Forfaldsdato", 13}, {"Kunde", 32}, {"Beskrivelse", 36}, {"Status", 10},
+			{"Valuta", 8}, {"Beløb ekskl. moms", 16}, {"Beløb inkl. moms", 16}, {"Ekstern reference", 18}, {"Faktura-ID", 38},
+		},
+	}
+	for _, invoice := range invoices {
+		// Drafts have no number yet
+		number := textCell("")
+		if invoice.Number != 0 {
+			number = intCell(invoice.Number)
+		}
+		sheet.Rows = append(sheet.Rows, []xlsxCell{
+			number,
+			dateCell(invoice.Date),
+			dateCell(invoice.PaymentDate),
+			textCell(invoice.ContactName),
+			textCell(invoice.Description),
+			textCell(invoice.Status),
+			textCell(invoice.Currency),
+			amountCell(invoice.TotalExclVat),
+			amountCell(invoice.TotalInclVat),
+			textCell(invoice.ExternalReference),
+			textCell(invoice.Guid),
+		})
+	}
+	return sheet
+}
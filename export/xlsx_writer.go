@@ -0,0 +1,301 @@
+package export
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rostved/dinero-backup/backup"
+)
+
+// The XLSX writer produces the minimal SpreadsheetML package Excel, LibreOffice and Numbers
+// accept: one worksheet per sheet with inline strings, a frozen header row and an autofilter.
+
+// Cell styles, indexes into cellXfs in xlsxStyles
+const (
+	xlsxStyleDefault = iota
+	xlsxStyleHeader
+	xlsxStyleDate
+	xlsxStyleAmount
+)
+
+type xlsxCellKind int
+
+const (
+	xlsxText xlsxCellKind = iota
+	xlsxNumber
+	xlsxDate
+	xlsxAmount
+)
+
+type xlsxCell struct {
+	Kind  xlsxCellKind
+	Value string
+}
+
+type xlsxColumn struct {
+	Header string
+	Width  float64
+}
+
+// xlsxSheet is a worksheet with a header row
+type xlsxSheet struct {
+	Name    string
+	Columns []xlsxColumn
+	Rows    [][]xlsxCell
+}
+
+func textCell(s string) xlsxCell {
+	return xlsxCell{Kind: xlsxText, Value: s}
+}
+
+func intCell(n int) xlsxCell {
+	return xlsxCell{Kind: xlsxNumber, Value: strconv.Itoa(n)}
+}
+
+func amountCell(amount backup.Money) xlsxCell {
+	return xlsxCell{Kind: xlsxAmount, Value: amount.String()}
+}
+
+// dateCell converts a date (YYYY-MM-DD, optionally followed by a time) to a date cell,
+// falling back to a text cell for anything else
+func dateCell(date string) xlsxCell {
+	if len(date) < 10 {
+		return textCell(date)
+	}
+	t, err := time.Parse("2006-01-02", date[:10])
+	if err != nil {
+		return textCell(date)
+	}
+	// Spreadsheet dates are days since 1899-12-30
+	days := t.Sub(time.Date(1899, 12, 30, 0, 0, 0, 0, time.UTC)).Hours() / 24
+	return xlsxCell{Kind: xlsxDate, Value: strconv.Itoa(int(days))}
+}
+
+// xlsxInvalidSheetChars can't be used in sheet names
+const xlsxInvalidSheetChars = `[]:*?/\`
+
+// xlsxSheetName makes a valid sheet name: at most 31 characters without []:*?/\
+func xlsxSheetName(name string) string {
+	name = strings.Map(func(r rune) rune {
+		if strings.ContainsRune(xlsxInvalidSheetChars, r) {
+			return '_'
+		}
+		return r
+	}, name)
+	if runes := []rune(name); len(runes) > 31 {
+		name = string(runes[:31])
+	}
+	return name
+}
+
+// writeXLSX writes the sheets as a workbook
+func writeXLSX(path string, sheets []*xlsxSheet) error {
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+
+	files := []struct {
+		Name    string
+		Content string
+	}{
+		{"[Content_Types].xml", xlsxContentTypes(len(sheets))},
+		{"_rels/.rels", xlsxRootRels},
+		{"xl/workbook.xml", xlsxWorkbook(sheets)},
+		{"xl/_rels/workbook.xml.rels", xlsxWorkbookRels(len(sheets))},
+		{"xl/styles.xml", xlsxStyles},
+	}
+	for i, sheet := range sheets {
+		files = append(files, struct {
+			Name    string
+			Content string
+		}{fmt.Sprintf("xl/worksheets/sheet%d.xml", i+1), xlsxWorksheet(sheet)})
+	}
+
+	for _, file := range files {
+		w, err := zw.Create(file.Name)
+		if err != nil {
+			return err
+		}
+		if _, err := w.Write([]byte(file.Content)); err != nil {
+			return err
+		}
+	}
+	if err := zw.Close(); err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(path, buf.Bytes(), 0644)
+}
+
+const xlsxHeader = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>` + "\n"
+
+const xlsxRootRels = xlsxHeader + `<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">` +
+	`<Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/officeDocument" Target="xl/workbook.xml"/>` +
+	`</Relationships>`
+
+// xlsxStyles defines the cell styles: default, bold header, date (dd-mm-yyyy) and amount (#,##0.00)
+const xlsxStyles = xlsxHeader + `<styleSheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main">` +
+	`<numFmts count="1"><numFmt numFmtId="164" formatCode="dd\-mm\-yyyy"/></numFmts>` +
+	`<fonts count="2"><font><sz val="11"/><name val="Calibri"/></font><font><b/><sz val="11"/><name val="Calibri"/></font></fonts>` +
+	`<fills count="2"><fill><patternFill patternType="none"/></fill><fill><patternFill patternType="gray125"/></fill></fills>` +
+	`<borders count="1"><border><left/><right/><top/><bottom/><diagonal/></border></borders>` +
+	`<cellStyleXfs count="1"><xf numFmtId="0" fontId="0" fillId="0" borderId="0"/></cellStyleXfs>` +
+	`<cellXfs count="4">` +
+	`<xf numFmtId="0" fontId="0" fillId="0" borderId="0" xfId="0"/>` +
+	`<xf numFmtId="0" fontId="1" fillId="0" borderId="0" xfId="0" applyFont="1"/>` +
+	`<xf numFmtId="164" fontId="0" fillId="0" borderId="0" xfId="0" applyNumberFormat="1"/>` +
+	`<xf numFmtId="4" fontId="0" fillId="0" borderId="0" xfId="0" applyNumberFormat="1"/>` +
+	`</cellXfs>` +
+	`<cellStyles count="1"><cellStyle name="Normal" xfId="0" builtinId="0"/></cellStyles>` +
+	`</styleSheet>`
+
+func xlsxContentTypes(sheets int) string {
+	var b strings.Builder
+	b.WriteString(xlsxHeader)
+	b.WriteString(`<Types xmlns="http://schemas.openxmlformats.org/package/2006/content-types">`)
+	b.WriteString(`<Default Extension="rels" ContentType="application/vnd.openxmlformats-package.relationships+xml"/>`)
+	b.WriteString(`<Default Extension="xml" ContentType="application/xml"/>`)
+	b.WriteString(`<Override PartName="/xl/workbook.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.sheet.main+xml"/>`)
+	b.WriteString(`<Override PartName="/xl/styles.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.styles+xml"/>`)
+	for i := 1; i <= sheets; i++ {
+		fmt.Fprintf(&b, `<Override PartName="/xl/worksheets/sheet%d.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.worksheet+xml"/>`, i)
+	}
+	b.WriteString(`</Types>`)
+	return b.String()
+}
+
+func xlsxWorkbook(sheets []*xlsxSheet) string {
+	var b strings.Builder
+	b.WriteString(xlsxHeader)
+	b.WriteString(`<workbook xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main" xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships">`)
+	b.WriteString(`<sheets>`)
+	for i, sheet := range sheets {
+		fmt.Fprintf(&b, `<sheet name="%s" sheetId="%d" r:id="rId%d"/>`, xmlEscape(sheet.Name), i+1, i+1)
+	}
+	b.WriteString(`</sheets>`)
+
+	// Excel keeps the autofilter range in a hidden defined name per sheet
+	b.WriteString(`<definedNames>`)
+	for i, sheet := range sheets {
+		name := "'" + strings.ReplaceAll(sheet.Name, "'", "''") + "'"
+		fmt.Fprintf(&b, `<definedName name="_xlnm._FilterDatabase" localSheetId="%d" hidden="1">%s!%s</definedName>`,
+			i, xmlEscape(name), xlsxAbsoluteRange(sheet.filterRange()))
+	}
+	b.WriteString(`</definedNames>`)
+
+	b.WriteString(`</workbook>`)
+	return b.String()
+}
+
+func xlsxWorkbookRels(sheets int) string {
+	var b strings.Builder
+	b.WriteString(xlsxHeader)
+	b.WriteString(`<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">`)
+	for i := 1; i <= sheets; i++ {
+		fmt.Fprintf(&b, `<Relationship Id="rId%d" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/worksheet" Target="worksheets/sheet%d.xml"/>`, i, i)
+	}
+	fmt.Fprintf(&b, `<Relationship Id="rId%d" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/styles" Target="styles.xml"/>`, sheets+1)
+	b.WriteString(`</Relationships>`)
+	return b.String()
+}
+
+func xlsxWorksheet(sheet *xlsxSheet) string {
+	var b strings.Builder
+	b.WriteString(xlsxHeader)
+	b.WriteString(`<worksheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main">`)
+	b.WriteString(`<sheetViews><sheetView workbookViewId="0">`)
+	b.WriteString(`<pane ySplit="1" topLeftCell="A2" activePane="bottomLeft" state="frozen"/>`)
+	b.WriteString(`<selection pane="bottomLeft" activeCell="A2" sqref="A2"/>`)
+	b.WriteString(`</sheetView></sheetViews>`)
+
+	b.WriteString(`<cols>`)
+	for i, column := range sheet.Columns {
+		fmt.Fprintf(&b, `<col min="%d" max="%d" width="%g" customWidth="1"/>`, i+1, i+1, column.Width)
+	}
+	b.WriteString(`</cols>`)
+
+	b.WriteString(`<sheetData>`)
+	header := make([]xlsxCell, len(sheet.Columns))
+	for i, column := range sheet.Columns {
+		header[i] = textCell(column.Header)
+	}
+	writeXLSXRow(&b, 1, header, xlsxStyleHeader)
+	for i, row := range sheet.Rows {
+		writeXLSXRow(&b, i+2, row, xlsxStyleDefault)
+	}
+	b.WriteString(`</sheetData>`)
+
+	fmt.Fprintf(&b, `<autoFilter ref="%s"/>`, sheet.filterRange())
+	b.WriteString(`</worksheet>`)
+	return b.String()
+}
+
+func writeXLSXRow(b *strings.Builder, number int, cells []xlsxCell, textStyle int) {
+	fmt.Fprintf(b, `<row r="%d">`, number)
+	for i, cell := range cells {
+		ref := xlsxColumnName(i) + strconv.Itoa(number)
+		switch cell.Kind {
+		case xlsxNumber:
+			fmt.Fprintf(b, `<c r="%s"><v>%s</v></c>`, ref, cell.Value)
+		case xlsxDate:
+			fmt.Fprintf(b, `<c r="%s" s="%d"><v>%s</v></c>`, ref, xlsxStyleDate, cell.Value)
+		case xlsxAmount:
+			fmt.Fprintf(b, `<c r="%s" s="%d"><v>%s</v></c>`, ref, xlsxStyleAmount, cell.Value)
+		default:
+			if cell.Value == "" {
+				continue
+			}
+			style := ""
+			if textStyle != xlsxStyleDefault {
+				style = fmt.Sprintf(` s="%d"`, textStyle)
+			}
+			fmt.Fprintf(b, `<c r="%s"%s t="inlineStr"><is><t xml:space="preserve">%s</t></is></c>`, ref, style, xmlEscape(cell.Value))
+		}
+	}
+	b.WriteString(`</row>`)
+}
+
+// filterRange is the range covered by the autofilter: the header and all rows
+func (s *xlsxSheet) filterRange() string {
+	columns := len(s.Columns)
+	if columns == 0 {
+		columns = 1
+	}
+	return fmt.Sprintf("A1:%s%d", xlsxColumnName(columns-1), len(s.Rows)+1)
+}
+
+// xlsxAbsoluteRange converts a range like A1:I10 to $A$1:$I$10
+func xlsxAbsoluteRange(ref string) string {
+	parts := strings.Split(ref, ":")
+	for i, part := range parts {
+		split := strings.IndexAny(part, "0123456789")
+		parts[i] = "$" + part[:split] + "$" + part[split:]
+	}
+	return strings.Join(parts, ":")
+}
+
+// xlsxColumnName returns the column letters for a zero-based column index (0 → A, 26 → AA)
+func xlsxColumnName(index int) string {
+	name := ""
+	for index >= 0 {
+		name = string(rune('A'+index%26)) + name
+		index = index/26 - 1
+	}
+	return name
+}
+
+// xmlEscape escapes text for XML, replacing characters XML can't contain
+func xmlEscape(s string) string {
+	var b strings.Builder
+	xml.EscapeText(&b, []byte(s))
+	return b.String()
+}
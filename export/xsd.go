@@ -0,0 +1,414 @@
+package export
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// The XSD validator supports the subset of XML Schema used by the bundled schemas:
+// global and inline complex types with xs:sequence and xs:choice, minOccurs/maxOccurs,
+// simple types restricting a built-in type with length, maxLength and enumeration facets,
+// and the built-in types string, decimal, integer, nonNegativeInteger and date.
+
+// maxValidationErrors caps the number of errors reported for one document
+const maxValidationErrors = 20
+
+type xsdSchema struct {
+	targetNamespace string
+	elements        map[string]*xsdElement
+	complexTypes    map[string]*xsdComplexType
+	simpleTypes     map[string]*xsdSimpleType
+}
+
+type xsdElement struct {
+	name      string
+	typeName  string
+	complex   *xsdComplexType
+	minOccurs int
+	maxOccurs int // -1 for unbounded
+	isChoice  bool
+	choiceOf  []*xsdElement
+}
+
+type xsdComplexType struct {
+	sequence []*xsdElement
+}
+
+type xsdSimpleType struct {
+	base        string
+	length      int
+	maxLength   int
+	enumeration []string
+}
+
+// Raw XSD as decoded by encoding/xml; particles keep their document order
+type rawSchema struct {
+	TargetNamespace string           `xml:"targetNamespace,attr"`
+	Elements        []rawParticle    `xml:"element"`
+	ComplexTypes    []rawComplexType `xml:"complexType"`
+	SimpleTypes     []rawSimpleType  `xml:"simpleType"`
+}
+
+type rawComplexType struct {
+	Name     string       `xml:"name,attr"`
+	Sequence *rawParticle `xml:"sequence"`
+}
+
+type rawParticle struct {
+	XMLName     xml.Name
+	Name        string          `xml:"name,attr"`
+	Type        string          `xml:"type,attr"`
+	MinOccurs   string          `xml:"minOccurs,attr"`
+	MaxOccurs   string          `xml:"maxOccurs,attr"`
+	ComplexType *rawComplexType `xml:"complexType"`
+	Particles   []rawParticle   `xml:",any"`
+}
+
+type rawSimpleType struct {
+	Name        string `xml:"name,attr"`
+	Restriction struct {
+		Base        string     `xml:"base,attr"`
+		Length      *rawFacet  `xml:"length"`
+		MaxLength   *rawFacet  `xml:"maxLength"`
+		Enumeration []rawFacet `xml:"enumeration"`
+	} `xml:"restriction"`
+}
+
+type rawFacet struct {
+	Value string `xml:"value,attr"`
+}
+
+// parseXSD reads an XML schema in the supported subset
+func parseXSD(data []byte) (*xsdSchema, error) {
+	var raw rawSchema
+	if err := xml.Unmarshal(data, &raw); err != nil {
+		return nil, fmt.Errorf("invalid schema: %w", err)
+	}
+
+	schema := &xsdSchema{
+		targetNamespace: raw.TargetNamespace,
+		elements:        make(map[string]*xsdElement),
+		complexTypes:    make(map[string]*xsdComplexType),
+		simpleTypes:     make(map[string]*xsdSimpleType),
+	}
+
+	for _, st := range raw.SimpleTypes {
+		simple := &xsdSimpleType{base: localName(st.Restriction.Base)}
+		if st.Restriction.Length != nil {
+			simple.length, _ = strconv.Atoi(st.Restriction.Length.Value)
+		}
+		if st.Restriction.MaxLength != nil {
+			simple.maxLength, _ = strconv.Atoi(st.Restriction.MaxLength.Value)
+		}
+		for _, facet := range st.Restriction.Enumeration {
+			simple.enumeration = append(simple.enumeration, facet.Value)
+		}
+		schema.simpleTypes[st.Name] = simple
+	}
+
+	for _, ct := range raw.ComplexTypes {
+		complexType, err := convertComplexType(&ct)
+		if err != nil {
+			return nil, err
+		}
+		schema.complexTypes[ct.Name] = complexType
+	}
+
+	for _, el := range raw.Elements {
+		element, err := convertParticle(el)
+		if err != nil {
+			return nil, err
+		}
+		schema.elements[element.name] = element
+	}
+
+	return schema, nil
+}
+
+func convertComplexType(raw *rawComplexType) (*xsdComplexType, error) {
+	complexType := &xsdComplexType{}
+	if raw.Sequence == nil {
+		return complexType, nil
+	}
+	for _, particle := range raw.Sequence.Particles {
+		element, err := convertParticle(particle)
+		if err != nil {
+			return nil, err
+		}
+		complexType.sequence = append(complexType.sequence, element)
+	}
+	return complexType, nil
+}
+
+func convertParticle(raw rawParticle) (*xsdElement, error) {
+	element := &xsdElement{name: raw.Name, typeName: localName(raw.Type), minOccurs: 1, maxOccurs: 1}
+
+	if raw.MinOccurs != "" {
+		n, err := strconv.Atoi(raw.MinOccurs)
+		if err != nil {
+			return nil, fmt.Errorf("invalid minOccurs %q on %s", raw.MinOccurs, raw.Name)
+		}
+		element.minOccurs = n
+	}
+	switch raw.MaxOccurs {
+	case "":
+	case "unbounded":
+		element.maxOccurs = -1
+	default:
+		n, err := strconv.Atoi(raw.MaxOccurs)
+		if err != nil {
+			return nil, fmt.Errorf("invalid maxOccurs %q on %s", raw.MaxOccurs, raw.Name)
+		}
+		element.maxOccurs = n
+	}
+
+	switch raw.XMLName.Local {
+	case "element":
+		if raw.ComplexType != nil {
+			complexType, err := convertComplexType(raw.ComplexType)
+			if err != nil {
+				return nil, err
+			}
+			element.complex = complexType
+		}
+	case "choice":
+		element.isChoice = true
+		for _, particle := range raw.Particles {
+			alternative, err := convertParticle(particle)
+			if err != nil {
+				return nil, err
+			}
+			element.choiceOf = append(element.choiceOf, alternative)
+		}
+	default:
+		return nil, fmt.Errorf("unsupported schema construct xs:%s", raw.XMLName.Local)
+	}
+
+	return element, nil
+}
+
+func localName(qualified string) string {
+	if i := strings.IndexByte(qualified, ':'); i >= 0 {
+		return qualified[i+1:]
+	}
+	return qualified
+}
+
+// xmlNode is an element of the document being validated
+type xmlNode struct {
+	name     xml.Name
+	children []*xmlNode
+	text     strings.Builder
+}
+
+func parseXMLTree(r io.Reader) (*xmlNode, error) {
+	decoder := xml.NewDecoder(r)
+	var stack []*xmlNode
+	var root *xmlNode
+
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		switch t := token.(type) {
+		case xml.StartElement:
+			node := &xmlNode{name: t.Name}
+			if len(stack) > 0 {
+				parent := stack[len(stack)-1]
+				parent.children = append(parent.children, node)
+			} else {
+				root = node
+			}
+			stack = append(stack, node)
+		case xml.EndElement:
+			stack = stack[:len(stack)-1]
+		case xml.CharData:
+			if len(stack) > 0 {
+				stack[len(stack)-1].text.Write(t)
+			}
+		}
+	}
+
+	if root == nil {
+		return nil, fmt.Errorf("document has no root element")
+	}
+	return root, nil
+}
+
+// validateXML validates a document against the schema and returns all errors found (up to a limit)
+func (s *xsdSchema) validateXML(data []byte) error {
+	root, err := parseXMLTree(bytes.NewReader(data))
+	if err != nil {
+		return fmt.Errorf("document is not well-formed: %w", err)
+	}
+
+	v := &xmlValidator{schema: s}
+	element, ok := s.elements[root.name.Local]
+	if !ok {
+		v.errorf("/%s: unexpected root element", root.name.Local)
+	} else {
+		v.validateElement(root, element, "")
+	}
+
+	if len(v.errors) == 0 {
+		return nil
+	}
+	return fmt.Errorf("document doesn't match schema:\n  %s", strings.Join(v.errors, "\n  "))
+}
+
+type xmlValidator struct {
+	schema *xsdSchema
+	errors []string
+}
+
+func (v *xmlValidator) errorf(format string, args ...any) {
+	if len(v.errors) < maxValidationErrors {
+		v.errors = append(v.errors, fmt.Sprintf(format, args...))
+	}
+}
+
+func (v *xmlValidator) validateElement(node *xmlNode, element *xsdElement, parentPath string) {
+	path := parentPath + "/" + node.name.Local
+
+	if node.name.Space != v.schema.targetNamespace {
+		v.errorf("%s: namespace %q, expected %q", path, node.name.Space, v.schema.targetNamespace)
+	}
+
+	complexType := element.complex
+	if complexType == nil {
+		complexType = v.schema.complexTypes[element.typeName]
+	}
+
+	if complexType != nil {
+		if strings.TrimSpace(node.text.String()) != "" {
+			v.errorf("%s: unexpected text content", path)
+		}
+		v.validateSequence(node.children, complexType.sequence, path)
+		return
+	}
+
+	if len(node.children) > 0 {
+		v.errorf("%s: unexpected child element %s", path, node.children[0].name.Local)
+		return
+	}
+	if err := v.validateSimple(node.text.String(), element.typeName); err != nil {
+		v.errorf("%s: %v", path, err)
+	}
+}
+
+// validateSequence matches children against the sequence greedily, which is sufficient as
+// no particle in the supported schemas can be followed by an element of the same name
+func (v *xmlValidator) validateSequence(children []*xmlNode, sequence []*xsdElement, path string) {
+	i := 0
+	for _, particle := range sequence {
+		count := 0
+		for i < len(children) && (particle.maxOccurs < 0 || count < particle.maxOccurs) {
+			matched := particle.match(children[i].name.Local)
+			if matched == nil {
+				break
+			}
+			v.validateElement(children[i], matched, path)
+			i++
+			count++
+		}
+		if count < particle.minOccurs {
+			v.errorf("%s: missing %s", path, particle.describe())
+		}
+	}
+	if i < len(children) {
+		v.errorf("%s: unexpected element %s", path, children[i].name.Local)
+	}
+}
+
+// match returns the element declaration matching name, or nil
+func (e *xsdElement) match(name string) *xsdElement {
+	if !e.isChoice {
+		if e.name == name {
+			return e
+		}
+		return nil
+	}
+	for _, alternative := range e.choiceOf {
+		if alternative.name == name {
+			return alternative
+		}
+	}
+	return nil
+}
+
+func (e *xsdElement) describe() string {
+	if !e.isChoice {
+		return e.name
+	}
+	var names []string
+	for _, alternative := range e.choiceOf {
+		names = append(names, alternative.name)
+	}
+	return strings.Join(names, " or ")
+}
+
+var (
+	decimalPattern = regexp.MustCompile(`^[+-]?(\d+(\.\d*)?|\.\d+)$`)
+	integerPattern = regexp.MustCompile(`^[+-]?\d+$`)
+)
+
+func (v *xmlValidator) validateSimple(value, typeName string) error {
+	if simple, ok := v.schema.simpleTypes[typeName]; ok {
+		if err := v.validateSimple(value, simple.base); err != nil {
+			return err
+		}
+		length := utf8.RuneCountInString(value)
+		if simple.length > 0 && length != simple.length {
+			return fmt.Errorf("value %q must be %d characters", value, simple.length)
+		}
+		if simple.maxLength > 0 && length > simple.maxLength {
+			return fmt.Errorf("value %q is longer than %d characters", value, simple.maxLength)
+		}
+		if len(simple.enumeration) > 0 {
+			for _, allowed := range simple.enumeration {
+				if value == allowed {
+					return nil
+				}
+			}
+			return fmt.Errorf("value %q is not one of %s", value, strings.Join(simple.enumeration, ", "))
+		}
+		return nil
+	}
+
+	trimmed := strings.TrimSpace(value)
+	switch typeName {
+	case "string", "":
+		return nil
+	case "decimal":
+		if !decimalPattern.MatchString(trimmed) {
+			return fmt.Errorf("value %q is not a decimal", value)
+		}
+	case "integer":
+		if !integerPattern.MatchString(trimmed) {
+			return fmt.Errorf("value %q is not an integer", value)
+		}
+	case "nonNegativeInteger":
+		if !integerPattern.MatchString(trimmed) || strings.HasPrefix(trimmed, "-") && strings.Trim(trimmed, "-0") != "" {
+			return fmt.Errorf("value %q is not a non-negative integer", value)
+		}
+	case "date":
+		if _, err := time.Parse("2006-01-02", trimmed); err != nil {
+			return fmt.Errorf("value %q is not a date (YYYY-MM-DD)", value)
+		}
+	default:
+		return fmt.Errorf("unsupported type %s", typeName)
+	}
+	return nil
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"encoding/json"
 	"fmt"
 	"log"
 	"os"
@@ -11,6 +12,7 @@ import (
 	"github.com/joho/godotenv"
 	"github.com/rostved/dinero-backup/backup"
 	"github.com/rostved/dinero-backup/dinero"
+	"github.com/rostved/dinero-backup/export"
 	"github.com/rostved/dinero-backup/state"
 	"github.com/spf13/cobra"
 )
@@ -21,14 +23,33 @@ var (
 	debug  bool
 
 	// Run command flags
-	dryRun      bool
-	csvOutput   bool
-	reports     bool
-	invoices    bool
-	creditNotes bool
-	entries     bool
-	vouchers    bool
-	contacts    bool
+	dryRun       bool
+	csvOutput    bool
+	reports      bool
+	invoices     bool
+	creditNotes  bool
+	entries      bool
+	vouchers     bool
+	contacts     bool
+	organization bool
+
+	reconcile        bool
+	reconcileReplace bool
+
+	reportPeriods         string
+	reportPreviousYear    bool
+	reportZeroAccounts    bool
+	reportSummaryAccounts bool
+
+	selectYear string
+	selectFrom string
+	selectTo   string
+
+	// Export command flags
+	exportYear    string
+	exportOutput  string
+	exportDialect string
+	exportMapping string
 )
 
 var rootCmd = &cobra.Command{
@@ -48,6 +69,66 @@ var stateCmd = &cobra.Command{
 	Run:   showState,
 }
 
+var entriesCmd = &cobra.Command{
+	Use:   "entries",
+	Short: "Inspect backed up entries",
+}
+
+var entriesHistoryCmd = &cobra.Command{
+	Use:   "history <entry-guid>",
+	Short: "Show how an entry's amount, account or date evolved",
+	Args:  cobra.ExactArgs(1),
+	Run:   showEntryHistory,
+}
+
+var exportCmd = &cobra.Command{
+	Use:   "export",
+	Short: "Export the local backup to other formats",
+}
+
+var exportSAFTCmd = &cobra.Command{
+	Use:   "saft",
+	Short: "Export an accounting year as SAF-T Financial XML",
+	Run:   exportSAFT,
+}
+
+var exportSIECmd = &cobra.Command{
+	Use:   "sie",
+	Short: "Export an accounting year as a SIE 4 file",
+	Run:   exportSIE,
+}
+
+var exportXLSXCmd = &cobra.Command{
+	Use:   "xlsx",
+	Short: "Export entries, contacts and invoices as an Excel workbook",
+	Run:   exportXLSX,
+}
+
+var exportCSVCmd = &cobra.Command{
+	Use:   "csv",
+	Short: "Export an accounting year's entries as CSV in a configurable dialect",
+	Run:   exportCSV,
+}
+
+var exportBeancountCmd = &cobra.Command{
+	Use:   "beancount",
+	Short: "Export an accounting year as a Beancount file",
+	Run:   exportBeancount,
+}
+
+var exportLedgerCmd = &cobra.Command{
+	Use:   "ledger",
+	Short: "Export an accounting year as a ledger-cli journal",
+	Run:   exportLedger,
+}
+
+var exportSQLiteCmd = &cobra.Command{
+	Use:   "sqlite <path>",
+	Short: "Load the backup into a SQLite database, updating it incrementally",
+	Args:  cobra.ExactArgs(1),
+	Run:   exportSQLite,
+}
+
 var testConnectionCmd = &cobra.Command{
 	Use:   "test-connection",
 	Short: "Test API connection and credentials",
@@ -61,17 +142,52 @@ func init() {
 
 	// Run command flags
 	runCmd.Flags().BoolVar(&dryRun, "dry-run", false, "Run without saving files or updating state")
-	runCmd.Flags().BoolVar(&csvOutput, "csv", false, "Output entries in CSV format instead of JSON")
+	runCmd.Flags().BoolVar(&csvOutput, "csv", false, "Also write CSV files for entries, invoices, credit notes, contacts and the file index")
 	runCmd.Flags().BoolVar(&reports, "reports", false, "Backup reports")
 	runCmd.Flags().BoolVar(&invoices, "invoices", false, "Backup invoices")
 	runCmd.Flags().BoolVar(&creditNotes, "creditnotes", false, "Backup credit notes")
 	runCmd.Flags().BoolVar(&entries, "entries", false, "Backup entries")
 	runCmd.Flags().BoolVar(&vouchers, "vouchers", false, "Backup vouchers")
 	runCmd.Flags().BoolVar(&contacts, "contacts", false, "Backup contacts")
+	runCmd.Flags().BoolVar(&organization, "organization", false, "Backup organization profile and settings")
+	runCmd.Flags().BoolVar(&reconcile, "reconcile", false, "Fetch each accounting year fresh and write an entries drift report")
+	runCmd.Flags().BoolVar(&reconcileReplace, "reconcile-replace", false, "With --reconcile, replace local entries with the fresh fetch")
+	runCmd.Flags().StringVar(&reportPeriods, "report-periods", "", "Also fetch result and balance reports per period: monthly or quarterly")
+	runCmd.Flags().BoolVar(&reportPreviousYear, "report-previous-year", false, "Include previous year comparison in reports")
+	runCmd.Flags().BoolVar(&reportZeroAccounts, "report-zero-accounts", false, "Include accounts without movements in reports")
+	runCmd.Flags().BoolVar(&reportSummaryAccounts, "report-summary-accounts", false, "Include summary accounts in reports")
+	runCmd.Flags().StringVar(&selectYear, "year", "", "Only re-pull this accounting year, without updating the sync state")
+	runCmd.Flags().StringVar(&selectFrom, "from", "", "Only re-pull data dated from this date (YYYY-MM-DD), without updating the sync state")
+	runCmd.Flags().StringVar(&selectTo, "to", "", "Only re-pull data dated until this date (YYYY-MM-DD), without updating the sync state")
 
 	rootCmd.AddCommand(runCmd)
 	rootCmd.AddCommand(stateCmd)
+	entriesCmd.AddCommand(entriesHistoryCmd)
+	rootCmd.AddCommand(entriesCmd)
 	rootCmd.AddCommand(testConnectionCmd)
+
+	// Export command flags
+	exportSAFTCmd.Flags().StringVar(&exportYear, "year", "", "Accounting year to export (required)")
+	exportSAFTCmd.MarkFlagRequired("year")
+	exportSAFTCmd.Flags().StringVar(&exportMapping, "mapping", "", "SAF-T mapping file with contact person and account grouping (default: <out-dir>/"+export.SAFTMappingFile+")")
+	exportCmd.PersistentFlags().StringVarP(&exportOutput, "output", "o", "", "Output file (default: <out-dir>/exports/<name>)")
+	exportSIECmd.Flags().StringVar(&exportYear, "year", "", "Accounting year to export (required)")
+	exportSIECmd.MarkFlagRequired("year")
+	exportCmd.AddCommand(exportSAFTCmd)
+	exportCmd.AddCommand(exportSIECmd)
+	exportBeancountCmd.Flags().StringVar(&exportYear, "year", "", "Accounting year to export (required)")
+	exportBeancountCmd.MarkFlagRequired("year")
+	exportLedgerCmd.Flags().StringVar(&exportYear, "year", "", "Accounting year to export (required)")
+	exportLedgerCmd.MarkFlagRequired("year")
+	exportCSVCmd.Flags().StringVar(&exportYear, "year", "", "Accounting year to export (required)")
+	exportCSVCmd.MarkFlagRequired("year")
+	exportCSVCmd.Flags().StringVar(&exportDialect, "dialect", "", "CSV dialect: danish, english or plain (default: CSV_DIALECT or danish)")
+	exportCmd.AddCommand(exportCSVCmd)
+	exportCmd.AddCommand(exportXLSXCmd)
+	exportCmd.AddCommand(exportBeancountCmd)
+	exportCmd.AddCommand(exportLedgerCmd)
+	exportCmd.AddCommand(exportSQLiteCmd)
+	rootCmd.AddCommand(exportCmd)
 }
 
 func main() {
@@ -150,13 +266,184 @@ func showState(cmd *cobra.Command, args []string) {
 	fmt.Printf("  Entries:      %s\n", stateManager.State.LastSync.Entries)
 	fmt.Printf("  Vouchers:     %s\n", stateManager.State.LastSync.Vouchers)
 	fmt.Printf("  Contacts:     %s\n", stateManager.State.LastSync.Contacts)
+	fmt.Printf("  Organization: %s\n", stateManager.State.LastSync.Organization)
+
+	if len(stateManager.State.EntriesInitialized) > 0 {
+		years := make([]string, len(stateManager.State.EntriesInitialized))
+		copy(years, stateManager.State.EntriesInitialized)
+		sort.Strings(years)
+		fmt.Printf("\nEntries initialized for years: %v\n", years)
+	}
 
 	if len(stateManager.State.EntriesInitializedYears) > 0 {
 		years := make([]int, len(stateManager.State.EntriesInitializedYears))
 		copy(years, stateManager.State.EntriesInitializedYears)
 		sort.Ints(years)
-		fmt.Printf("\nEntries initialized for years: %v\n", years)
+		fmt.Printf("Entries initialized for calendar years (legacy): %v\n", years)
+	}
+}
+
+func showEntryHistory(cmd *cobra.Command, args []string) {
+	loadEnvAndOutDir(cmd)
+
+	entryGuid := args[0]
+	versions, err := backup.LoadEntryHistory(outDir, entryGuid)
+	if err != nil {
+		log.Fatalf("Error loading entry history: %v", err)
+	}
+
+	if len(versions) == 0 {
+		fmt.Printf("No history found for entry %s\n", entryGuid)
+		return
+	}
+
+	fmt.Printf("History for entry %s (%d versions):\n\n", entryGuid, len(versions))
+	for i, version := range versions {
+		var entry backup.Entry
+		if err := json.Unmarshal(version.Entry, &entry); err != nil {
+			log.Fatalf("Error parsing entry version: %v", err)
+		}
+
+		fmt.Printf("%s  (year %s)\n", version.SeenAt, version.Year)
+		fmt.Printf("  Date:    %s\n", entry.Date)
+		fmt.Printf("  Account: %d %s\n", entry.AccountNumber, entry.AccountName)
+		fmt.Printf("  Amount:  %s\n", entry.Amount)
+		fmt.Printf("  Text:    %s\n", entry.Description)
+		if entry.TombstonedAt != "" {
+			fmt.Printf("  Deleted in Dinero (detected %s)\n", entry.TombstonedAt)
+		}
+		if i > 0 {
+			changed := backup.ChangedFields(versions[i-1].Entry, version.Entry)
+			if len(changed) > 0 {
+				fmt.Printf("  Changed: %s\n", strings.Join(changed, ", "))
+			}
+		}
+		fmt.Println()
+	}
+}
+
+// openExportYear opens the local backup and looks up the accounting year given with --year
+func openExportYear() (*export.Backup, backup.FiscalYear) {
+	b, err := export.Open(outDir)
+	if err != nil {
+		log.Fatal(err)
+	}
+	year, err := b.Year(exportYear)
+	if err != nil {
+		log.Fatal(err)
+	}
+	return b, year
+}
+
+// exportPath returns the --output path, or the default file name in <out-dir>/exports/
+func exportPath(name string) string {
+	if exportOutput != "" {
+		return expandTilde(exportOutput)
+	}
+	return filepath.Join(outDir, "exports", name)
+}
+
+func exportSAFT(cmd *cobra.Command, args []string) {
+	loadEnvAndOutDir(cmd)
+
+	mappingPath := filepath.Join(outDir, export.SAFTMappingFile)
+	if exportMapping != "" {
+		mappingPath = expandTilde(exportMapping)
+	}
+	mapping, err := export.LoadSAFTMapping(mappingPath)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	b, year := openExportYear()
+	path := exportPath(fmt.Sprintf("saft_%s.xml", year.FileName()))
+	if err := export.SAFT(b, year, mapping, path); err != nil {
+		log.Fatalf("Error exporting SAF-T: %v", err)
 	}
+	log.Printf("Saved SAF-T file for year %s to %s", year.Name, path)
+}
+
+func exportSIE(cmd *cobra.Command, args []string) {
+	loadEnvAndOutDir(cmd)
+
+	b, year := openExportYear()
+	path := exportPath(fmt.Sprintf("sie_%s.se", year.FileName()))
+	if err := export.SIE(b, year, path); err != nil {
+		log.Fatalf("Error exporting SIE: %v", err)
+	}
+	log.Printf("Saved SIE file for year %s to %s", year.Name, path)
+}
+
+func exportXLSX(cmd *cobra.Command, args []string) {
+	loadEnvAndOutDir(cmd)
+
+	b, err := export.Open(outDir)
+	if err != nil {
+		log.Fatal(err)
+	}
+	path := exportPath("dinero.xlsx")
+	if err := export.XLSX(b, path); err != nil {
+		log.Fatalf("Error exporting workbook: %v", err)
+	}
+	log.Printf("Saved workbook to %s", path)
+}
+
+func exportCSV(cmd *cobra.Command, args []string) {
+	loadEnvAndOutDir(cmd)
+
+	// --dialect selects the predefined dialect; the CSV_* settings still apply on top of it
+	dialect, err := backup.LoadCSVDialect(func(key string) string {
+		if key == "CSV_DIALECT" && exportDialect != "" {
+			return exportDialect
+		}
+		return os.Getenv(key)
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	b, year := openExportYear()
+	path := exportPath(fmt.Sprintf("entries_%s_%s.csv", year.FileName(), dialect.Name))
+	if err := export.EntriesCSV(b, year, dialect, path); err != nil {
+		log.Fatalf("Error exporting CSV: %v", err)
+	}
+	log.Printf("Saved %s CSV for year %s to %s", dialect.Name, year.Name, path)
+}
+
+func exportBeancount(cmd *cobra.Command, args []string) {
+	loadEnvAndOutDir(cmd)
+
+	b, year := openExportYear()
+	path := exportPath(fmt.Sprintf("%s.beancount", year.FileName()))
+	if err := export.Beancount(b, year, path); err != nil {
+		log.Fatalf("Error exporting Beancount file: %v", err)
+	}
+	log.Printf("Saved Beancount file for year %s to %s", year.Name, path)
+}
+
+func exportLedger(cmd *cobra.Command, args []string) {
+	loadEnvAndOutDir(cmd)
+
+	b, year := openExportYear()
+	path := exportPath(fmt.Sprintf("%s.ledger", year.FileName()))
+	if err := export.Ledger(b, year, path); err != nil {
+		log.Fatalf("Error exporting ledger journal: %v", err)
+	}
+	log.Printf("Saved ledger journal for year %s to %s", year.Name, path)
+}
+
+func exportSQLite(cmd *cobra.Command, args []string) {
+	loadEnvAndOutDir(cmd)
+
+	b, err := export.Open(outDir)
+	if err != nil {
+		log.Fatal(err)
+	}
+	path := expandTilde(args[0])
+	if err := export.SQLite(b, path); err != nil {
+		log.Fatalf("Error exporting database: %v", err)
+	}
+	log.Printf("Saved database to %s", path)
 }
 
 func testConnection(cmd *cobra.Command, args []string) {
@@ -177,7 +464,7 @@ func testConnection(cmd *cobra.Command, args []string) {
 	fmt.Println("Connection successful!")
 	fmt.Printf("Found %d accounting year(s):\n", len(years))
 	for _, year := range years {
-		fmt.Printf("  - %d\n", year.Year())
+		fmt.Printf("  - %s (%s to %s)\n", year.Name, year.From.Format("2006-01-02"), year.To.Format("2006-01-02"))
 	}
 }
 
@@ -204,59 +491,108 @@ func runBackup(cmd *cobra.Command, args []string) {
 		log.Println("DRY RUN MODE: No files will be written, state will not be updated.")
 	}
 
-	// Determine what to backup
-	all := !reports && !invoices && !creditNotes && !entries && !vouchers && !contacts
+	selection, err := backup.ResolveSelection(client, selectYear, selectFrom, selectTo)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if selection != nil {
+		if reconcile || reconcileReplace {
+			log.Fatal("--reconcile can't be combined with --year, --from or --to")
+		}
+		log.Printf("SELECTIVE RUN: Re-pulling %s, sync state will not be updated.", selection)
+	}
+
+	// CSV settings are checked up front, so a configuration error doesn't surface halfway through the run
+	var csvDialect *backup.CSVDialect
+	if csvOutput {
+		csvDialect, err = backup.LoadCSVDialect(os.Getenv)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	// Determine what to backup. Organization and contacts aren't dated, so a selective run skips them unless asked for.
+	all := !reports && !invoices && !creditNotes && !entries && !vouchers && !contacts && !organization
 	runReports := all || reports
 	runInvoices := all || invoices
 	runCreditNotes := all || creditNotes
 	runEntries := all || entries
 	runVouchers := all || vouchers
-	runContacts := all || contacts
+	runContacts := (all && selection == nil) || contacts
+	runOrganization := (all && selection == nil) || organization
 
 	var hasErrors bool
 
+	if runOrganization {
+		if err := backup.BackupOrganization(client, stateManager, outDir, dryRun); err != nil {
+			log.Printf("Error backing up organization: %v", err)
+			hasErrors = true
+		}
+	}
+
 	if runReports {
-		if err := backup.BackupReports(client, outDir, dryRun); err != nil {
+		reportOptions := backup.ReportOptions{
+			Periods:         reportPeriods,
+			PreviousYear:    reportPreviousYear,
+			ZeroAccounts:    reportZeroAccounts,
+			SummaryAccounts: reportSummaryAccounts,
+		}
+		if err := reportOptions.Validate(); err != nil {
+			log.Printf("Error in report options: %v", err)
+			hasErrors = true
+		} else if err := backup.BackupReports(client, stateManager, outDir, dryRun, selection, reportOptions); err != nil {
 			log.Printf("Error backing up reports: %v", err)
 			hasErrors = true
 		}
 	}
 
 	if runInvoices {
-		if err := backup.BackupInvoices(client, stateManager, outDir, dryRun); err != nil {
+		if fields, err := backup.ResolveFields("invoices", os.Getenv("INVOICE_FIELDS")); err != nil {
+			log.Printf("Error in INVOICE_FIELDS: %v", err)
+			hasErrors = true
+		} else if err := backup.BackupInvoices(client, stateManager, outDir, dryRun, fields, selection, csvDialect); err != nil {
 			log.Printf("Error backing up invoices: %v", err)
 			hasErrors = true
 		}
 	}
 
 	if runCreditNotes {
-		if err := backup.BackupCreditNotes(client, stateManager, outDir, dryRun); err != nil {
+		if err := backup.BackupCreditNotes(client, stateManager, outDir, dryRun, selection, csvDialect); err != nil {
 			log.Printf("Error backing up credit notes: %v", err)
 			hasErrors = true
 		}
 	}
 
 	if runEntries {
-		if err := backup.BackupEntries(client, stateManager, outDir, dryRun, csvOutput); err != nil {
+		reconcileOptions := backup.ReconcileOptions{Enabled: reconcile || reconcileReplace, Replace: reconcileReplace}
+		if err := backup.BackupEntries(client, stateManager, outDir, dryRun, csvDialect, reconcileOptions, selection); err != nil {
 			log.Printf("Error backing up entries: %v", err)
 			hasErrors = true
 		}
 	}
 
 	if runVouchers {
-		if err := backup.BackupVouchers(client, stateManager, outDir, dryRun); err != nil {
+		if err := backup.BackupVouchers(client, stateManager, outDir, dryRun, selection, csvDialect); err != nil {
 			log.Printf("Error backing up vouchers: %v", err)
 			hasErrors = true
 		}
 	}
 
 	if runContacts {
-		if err := backup.BackupContacts(client, stateManager, outDir, dryRun); err != nil {
+		if fields, err := backup.ResolveFields("contacts", os.Getenv("CONTACT_FIELDS")); err != nil {
+			log.Printf("Error in CONTACT_FIELDS: %v", err)
+			hasErrors = true
+		} else if err := backup.BackupContacts(client, stateManager, outDir, dryRun, fields, csvDialect); err != nil {
 			log.Printf("Error backing up contacts: %v", err)
 			hasErrors = true
 		}
 	}
 
+	if err := backup.SaveSchemaDriftReport(outDir, dryRun); err != nil {
+		log.Printf("Error saving schema drift report: %v", err)
+		hasErrors = true
+	}
+
 	if hasErrors {
 		log.Println("Backup completed with errors.")
 		os.Exit(1)
@@ -7,17 +7,27 @@ import (
 )
 
 type LastSync struct {
-	Reports     string `json:"reports"`
-	Invoices    string `json:"invoices"`
-	CreditNotes string `json:"creditNotes"`
-	Entries     string `json:"entries"`
-	Vouchers    string `json:"vouchers"`
-	Contacts    string `json:"contacts"`
+	Reports      string `json:"reports"`
+	Invoices     string `json:"invoices"`
+	CreditNotes  string `json:"creditNotes"`
+	Entries      string `json:"entries"`
+	Vouchers     string `json:"vouchers"`
+	Contacts     string `json:"contacts"`
+	Organization string `json:"organization"`
 }
 
 type State struct {
-	LastSync                 LastSync `json:"lastSync"`
-	EntriesInitializedYears []int    `json:"entriesInitializedYears,omitempty"`
+	LastSync LastSync `json:"lastSync"`
+	// EntriesInitializedYears holds calendar years initialized before fiscal year support
+	EntriesInitializedYears []int `json:"entriesInitializedYears,omitempty"`
+	// EntriesInitialized holds names of accounting years whose entries have been fetched in full
+	EntriesInitialized []string `json:"entriesInitialized,omitempty"`
+	// EntriesReconciled holds the last time each accounting year was reconciled against a full fetch
+	EntriesReconciled map[string]string `json:"entriesReconciled,omitempty"`
+	// ReportsFetched holds the last time each closed accounting year's reports were fetched
+	ReportsFetched map[string]string `json:"reportsFetched,omitempty"`
+	// SelectedFields holds the field selection of the last invoices and contacts backup
+	SelectedFields map[string]string `json:"selectedFields,omitempty"`
 }
 
 type Manager struct {
@@ -27,12 +37,13 @@ type Manager struct {
 
 var DefaultState = State{
 	LastSync: LastSync{
-		Reports:     "2000-01-01T00:00:00Z",
-		Invoices:    "2000-01-01T00:00:00Z",
-		CreditNotes: "2000-01-01T00:00:00Z",
-		Entries:     "2000-01-01T00:00:00Z",
-		Vouchers:    "2000-01-01T00:00:00Z",
-		Contacts:    "2000-01-01T00:00:00Z",
+		Reports:      "2000-01-01T00:00:00Z",
+		Invoices:     "2000-01-01T00:00:00Z",
+		CreditNotes:  "2000-01-01T00:00:00Z",
+		Entries:      "2000-01-01T00:00:00Z",
+		Vouchers:     "2000-01-01T00:00:00Z",
+		Contacts:     "2000-01-01T00:00:00Z",
+		Organization: "2000-01-01T00:00:00Z",
 	},
 }
 
@@ -108,7 +119,24 @@ func (m *Manager) GetLastSyncContacts() string {
 	return m.State.LastSync.Contacts
 }
 
-func (m *Manager) IsEntryYearInitialized(year int) bool {
+func (m *Manager) UpdateOrganization(timestamp string) {
+	m.State.LastSync.Organization = timestamp
+}
+
+func (m *Manager) GetLastSyncOrganization() string {
+	return m.State.LastSync.Organization
+}
+
+func (m *Manager) IsEntryYearInitialized(name string) bool {
+	for _, y := range m.State.EntriesInitialized {
+		if y == name {
+			return true
+		}
+	}
+	return false
+}
+
+func (m *Manager) IsLegacyEntryYearInitialized(year int) bool {
 	for _, y := range m.State.EntriesInitializedYears {
 		if y == year {
 			return true
@@ -117,8 +145,45 @@ func (m *Manager) IsEntryYearInitialized(year int) bool {
 	return false
 }
 
-func (m *Manager) MarkEntryYearInitialized(year int) {
-	if !m.IsEntryYearInitialized(year) {
-		m.State.EntriesInitializedYears = append(m.State.EntriesInitializedYears, year)
+func (m *Manager) MarkEntryYearInitialized(name string) {
+	if !m.IsEntryYearInitialized(name) {
+		m.State.EntriesInitialized = append(m.State.EntriesInitialized, name)
+	}
+}
+
+func (m *Manager) GetEntriesReconciled(name string) string {
+	return m.State.EntriesReconciled[name]
+}
+
+func (m *Manager) UpdateEntriesReconciled(name string, timestamp string) {
+	if m.State.EntriesReconciled == nil {
+		m.State.EntriesReconciled = make(map[string]string)
+	}
+	m.State.EntriesReconciled[name] = timestamp
+}
+
+func (m *Manager) UpdateReports(timestamp string) {
+	m.State.LastSync.Reports = timestamp
+}
+
+func (m *Manager) GetReportsFetched(name string) string {
+	return m.State.ReportsFetched[name]
+}
+
+func (m *Manager) UpdateReportsFetched(name string, timestamp string) {
+	if m.State.ReportsFetched == nil {
+		m.State.ReportsFetched = make(map[string]string)
+	}
+	m.State.ReportsFetched[name] = timestamp
+}
+
+func (m *Manager) GetSelectedFields(resource string) string {
+	return m.State.SelectedFields[resource]
+}
+
+func (m *Manager) UpdateSelectedFields(resource string, fields string) {
+	if m.State.SelectedFields == nil {
+		m.State.SelectedFields = make(map[string]string)
 	}
+	m.State.SelectedFields[resource] = fields
 }